@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Age from which a borrower may apply in any state
+const adultAge = 18
+
+// Oldest age accepted, older dates of birth are deemed typos
+const maxAge = 120
+
+// Layouts of the dates of birth accepted besides MM/DD/YYYY
+var birthLayouts = []string{"1/2/2006", "2006-01-02", "01-02-2006", "01.02.2006",
+	"January 2, 2006", "Jan 2, 2006", "2 January 2006", "2 Jan 2006"}
+
+// State code of a state age flag
+var stateCodeRE = regexp.MustCompile(stateCode)
+
+// stateAges
+//
+// Minimum age of the borrowers by state code, where it differs from
+// adultAge. As a flag, a comma-separated list of state=age, e.g.
+// "AL=19,MS=21", overriding the states given.
+type stateAges map[string]int
+
+// Minimum ages of the borrowers by state of the property: the age of
+// majority where it is above 18
+var minimumAges = stateAges{"AL": 19, "NE": 19, "MS": 21}
+
+func (s stateAges) String() string {
+	states := make([]string, 0, len(s))
+	for state, age := range s {
+		states = append(states, fmt.Sprintf("%s=%d", state, age))
+	}
+	sort.Strings(states)
+	return strings.Join(states, ",")
+}
+
+func (s stateAges) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || !stateCodeRE.MatchString(kv[0]) {
+			return fmt.Errorf("invalid state age '%s', use <state>=<age>", pair)
+		}
+		age, err := strconv.Atoi(kv[1])
+		if err != nil || age < 1 || age > maxAge {
+			return fmt.Errorf("invalid age '%s' for %s", kv[1], kv[0])
+		}
+		s[strings.ToUpper(kv[0])] = age
+	}
+	return nil
+}
+
+// minimumAge
+//
+// Minimum age of the borrowers of a property in state, adultAge when the
+// state is not known yet
+func minimumAge(state string) int {
+	if age, ok := minimumAges[strings.ToUpper(state)]; ok {
+		return age
+	}
+	return adultAge
+}
+
+// AgeOn
+//
+// Age of the client on a date, e.g. that of the application. Clients of
+// applications saved before the date of birth was asked have the age
+// they gave then, 0 when neither is known.
+func (c *Client) AgeOn(t time.Time) int {
+	if c.Birth == nil {
+		return c.Age
+	}
+	age := t.Year() - c.Birth.Year()
+	if t.Month() < c.Birth.Month() || t.Month() == c.Birth.Month() && t.Day() < c.Birth.Day() {
+		age--
+	}
+	return age
+}
+
+// checkAge
+//
+// Error when the client is not old enough to borrow on a property in
+// state as of the application date. Clients whose age is not known yet
+// are not checked.
+func (c *Client) checkAge(state string, on time.Time) error {
+	age := c.AgeOn(on)
+	if age == 0 && c.Birth == nil {
+		return nil
+	}
+	min := minimumAge(state)
+	if age >= min {
+		return nil
+	}
+	if min != adultAge {
+		return fmt.Errorf("Borrowers must be at least %d years old in %s", min, strings.ToUpper(state))
+	}
+	return fmt.Errorf("Borrowers must be at least %d years old", min)
+}
+
+// birthCheck
+//
+// Check of a date of birth answered for a borrower of a property in
+// state, the date of the application being on
+func birthCheck(state string, on time.Time) func(data, value interface{}) error {
+	return func(data, value interface{}) error {
+		birth := value.(time.Time)
+		if birth.After(on) || birth.Before(on.AddDate(-maxAge, 0, 0)) {
+			return errors.New("Invalid date of birth")
+		}
+		return (&Client{Birth: &birth}).checkAge(state, on)
+	}
+}
+
+// propertyState
+//
+// State of the property when known: that of the loan terms, or of the
+// address of a refinanced property
+func (ctx *Context) propertyState() string {
+	switch {
+	case ctx.Property != nil && ctx.Property.State != "":
+		return ctx.Property.State
+	case ctx.LoanType == REFINANCE && ctx.Refinance != nil:
+		return ctx.Refinance.State
+	}
+	return ""
+}
+
+// stateCheck
+//
+// Check of the state of the property of the application in data: every
+// borrower known must be old enough to borrow there
+func stateCheck(data, value interface{}) error {
+	ctx := data.(*Context)
+	for _, c := range append([]*Client{ctx.Client}, ctx.CoBorrow...) {
+		if c == nil {
+			continue
+		}
+		if err := c.checkAge(value.(string), ctx.Created); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// Enum Print
+func (a assetType) String() string {
+	switch a {
+	case CHECKING:
+		return "checking"
+	case SAVINGS:
+		return "savings"
+	case INVESTMENT:
+		return "investment"
+	case RETIREMENT:
+		return "retirement"
+	}
+	return "invalid"
+}
+
+// Liquid
+//
+// Whether the account can be drawn on without penalty
+func (a assetType) Liquid() bool {
+	return a == CHECKING || a == SAVINGS || a == INVESTMENT
+}
+
+// Enum Print
+func (l liabilityType) String() string {
+	switch l {
+	case CREDITCARD:
+		return "credit card"
+	case AUTOLOAN:
+		return "auto loan"
+	case STUDENTLOAN:
+		return "student loan"
+	case MORTGAGE:
+		return "mortgage"
+	case OTHERDEBT:
+		return "other"
+	}
+	return "invalid"
+}
+
+// Asset Print
+func (a *Asset) String() string {
+	return fmt.Sprintf("  %12s: %s at %s\n", a.Type, money(a.Balance), a.Institution)
+}
+
+// Liability Print
+func (l *Liability) String() string {
+	return fmt.Sprintf("  %12s: %s/month to %s, %s owed\n",
+		l.Type, money(l.MonthlyPayment), l.Creditor, money(l.Balance))
+}
+
+// LiquidAssets
+//
+// Balance of the accounts which are liquid
+func (ctx *Context) LiquidAssets() float64 {
+	total := 0.0
+	for _, a := range ctx.Assets {
+		if a.Type.Liquid() {
+			total += a.Balance
+		}
+	}
+	return total
+}
+
+// MonthlyDebts
+//
+// Monthly payments of the liabilities
+func (ctx *Context) MonthlyDebts() float64 {
+	total := 0.0
+	for _, l := range ctx.Liabilities {
+		total += l.MonthlyPayment
+	}
+	return total
+}
+
+// DTI
+//
+// Front-end (housing payment) and back-end (housing payment and debts)
+// debt-to-income ratios in percent. ok is false until both the income
+// and the proposed housing payment are known.
+func (ctx *Context) DTI() (front, back float64, ok bool) {
+	income := ctx.MonthlyIncome()
+	if income <= 0 || ctx.ProposedPayment <= 0 {
+		return 0, 0, false
+	}
+	front = 100 * ctx.ProposedPayment / income
+	back = 100 * (ctx.ProposedPayment + ctx.MonthlyDebts()) / income
+	return front, back, true
+}
+
+// financesString
+//
+// Summary of the assets, liabilities and ratios
+func (ctx *Context) financesString() string {
+	buff := &bytes.Buffer{}
+	if len(ctx.Assets) > 0 {
+		buff.WriteString("\nASSETS\n")
+		for _, a := range ctx.Assets {
+			buff.WriteString(a.String())
+		}
+		buff.WriteString(fmt.Sprintf("  Liquid assets: %s\n", money(ctx.LiquidAssets())))
+	}
+	if len(ctx.Liabilities) > 0 {
+		buff.WriteString("\nLIABILITIES\n")
+		for _, l := range ctx.Liabilities {
+			buff.WriteString(l.String())
+		}
+		buff.WriteString(fmt.Sprintf("  Monthly debts: %s\n", money(ctx.MonthlyDebts())))
+	}
+	if ctx.ProposedPayment > 0 {
+		buff.WriteString(fmt.Sprintf("\nPROPOSED HOUSING PAYMENT: %s/month\n", money(ctx.ProposedPayment)))
+	}
+	if front, back, ok := ctx.DTI(); ok {
+		buff.WriteString(fmt.Sprintf("DEBT-TO-INCOME: %.1f%% front-end, %.1f%% back-end\n", front, back))
+	}
+	return buff.String()
+}
+
+// assetForm
+//
+// Questions collecting an account and its balance
+var assetForm = &Form{Name: "asset", Questions: []*Question{
+	&Question{Path: "type", Prompt: "What type of account is it?", Type: CHOICE,
+		Options: []string{"Checking", "Savings", "Investment", "Retirement"}},
+	&Question{Path: "institution", Prompt: "What is the financial institution?", Type: TEXT},
+	&Question{Path: "balance", Prompt: "What is the current balance?", Type: MONEY,
+		Optional: true, Max: maxAmount},
+}}
+
+// liabilityForm
+//
+// Questions collecting a debt, its balance and monthly payment
+var liabilityForm = &Form{Name: "liability", Questions: []*Question{
+	&Question{Path: "type", Prompt: "What type of debt is it?", Type: CHOICE,
+		Options: []string{"Credit card", "Auto loan", "Student loan", "Mortgage", "Other"}},
+	&Question{Path: "creditor", Prompt: "Who is the creditor?", Type: TEXT},
+	&Question{Path: "balance", Prompt: "What is the balance owed?", Type: MONEY,
+		Optional: true, Max: maxAmount},
+	&Question{Path: "monthly-payment", Prompt: "What is the monthly payment?", Type: MONEY,
+		Max: maxAmount},
+}}
+
+// debtsForm
+//
+// Questions collecting the proposed housing payment and whether the
+// client has debts
+var debtsForm = &Form{Name: "debts", Questions: []*Question{
+	&Question{Path: "proposed-payment", Type: MONEY, Optional: true, Max: maxAmount,
+		Prompt: "What is the proposed monthly housing payment, including taxes " +
+			"and insurance [empty if unknown]?",
+		Help: "Principal, interest, property taxes and insurance, used to compute " +
+			"your debt-to-income ratios."},
+	&Question{Path: "debts", Type: YESNO,
+		Prompt: "Do you have debts with monthly payments (credit cards, auto or student loans, mortgages)?"},
+}}
+
+// assets
+//
+// Ask whether the client holds accounts, enabling `assetList`
+func assets(ctx *Context) error {
+	yes, err := confirm(ctx.Run, "assets", "Do you have bank, investment or retirement accounts?")
+	if err != nil || !yes {
+		return err
+	}
+	return ctx.Enable("assetList")
+}
+
+// asset
+//
+// Collect an account and its balance
+func asset(r *workflow.Run) error {
+	a, ok := r.Data.(*Asset)
+	if !ok {
+		return fmt.Errorf("no asset in scope '%s'", r.Scope)
+	}
+	return frontendOf(r).Ask(assetForm, a, r)
+}
+
+// liabilities
+//
+// Collect the proposed housing payment and ask whether the client has
+// debts, enabling `liabilityList`
+func liabilities(ctx *Context) error {
+	answers := &struct {
+		Payment float64 `json:"proposed-payment"`
+		Debts   bool    `json:"debts"`
+	}{}
+	if err := frontendOf(ctx.Run).Ask(debtsForm, answers, ctx); err != nil {
+		return err
+	}
+
+	ctx.Lock()
+	ctx.ProposedPayment = answers.Payment
+	ctx.Unlock()
+	if !answers.Debts {
+		return nil
+	}
+	return ctx.Enable("liabilityList")
+}
+
+// liability
+//
+// Collect a debt, its balance and monthly payment
+func liability(r *workflow.Run) error {
+	l, ok := r.Data.(*Liability)
+	if !ok {
+		return fmt.Errorf("no liability in scope '%s'", r.Scope)
+	}
+	return frontendOf(r).Ask(liabilityForm, l, r)
+}
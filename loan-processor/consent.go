@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Disclosure
+//
+// Text an applicant agrees to, e.g. before their credit report is
+// pulled. A new version is added whenever the text changes, so that
+// the signatures of older versions can still be verified.
+type Disclosure struct {
+	Name     string
+	Version  string
+	Title    string
+	Text     string
+	Prompt   string
+	Required bool // the application cannot go on without agreeing
+}
+
+// Disclosures presented to the applicants, the current version of each
+// last
+var disclosures = []*Disclosure{
+	&Disclosure{Name: "credit-authorization", Version: "2026-10-01", Title: "CREDIT AUTHORIZATION",
+		Text: "By signing below, you authorize us to obtain your credit report from one or more\n" +
+			"consumer reporting agencies to evaluate your loan application. This inquiry may\n" +
+			"appear on your credit report.",
+		Prompt: "We need to pull your credit report. Do you authorize us to do so?"},
+	&Disclosure{Name: "submission", Version: "2026-10-01", Title: "APPLICATION CERTIFICATION",
+		Text: "By signing below, you certify that the information in this application is true\n" +
+			"and complete, and that you have read the disclosures presented to you. You agree\n" +
+			"to sign electronically and to receive notices about your application by email.",
+		Prompt:   "Do you certify your application and agree to sign electronically?",
+		Required: true},
+}
+
+// disclosure
+//
+// Disclosure by name, the current version when version is empty
+func disclosure(name, version string) *Disclosure {
+	var found *Disclosure
+	for _, d := range disclosures {
+		if d.Name == name && (version == "" || d.Version == version) {
+			found = d
+		}
+	}
+	return found
+}
+
+// Digest
+//
+// SHA-256 of the text of the disclosure, in hex
+func (d *Disclosure) Digest() string {
+	sum := sha256.Sum256([]byte(d.Text))
+	return hex.EncodeToString(sum[:])
+}
+
+// Key signing the consents
+var signatureKey = &serverKey{use: "sign consents", env: "SIGNATURE_KEY", fileEnv: "SIGNATURE_KEY_FILE"}
+
+// mac
+//
+// HMAC-SHA256 of text under the key signing the consents, in hex, so
+// that a modified application cannot be signed again without the key
+func mac(text []byte) (string, error) {
+	key, err := signatureKey.load()
+	if err != nil {
+		return "", err
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write(text)
+	return hex.EncodeToString(m.Sum(nil)), nil
+}
+
+// Signature
+//
+// Applicant's agreement to a version of a disclosure, signed by typing
+// their name. Hash chains the signature to the previous one and to the
+// snapshot of the application as signed, both HMACs under the server's
+// key.
+type Signature struct {
+	Disclosure string    `json:"disclosure"`
+	Version    string    `json:"version"`
+	Digest     string    `json:"digest"`
+	Signer     string    `json:"signer"`
+	Signed     time.Time `json:"signed"`
+	Snapshot   string    `json:"snapshot"`
+	Prev       string    `json:"prev,omitempty"`
+	Hash       string    `json:"hash"`
+}
+
+// hash
+//
+// HMAC of the signed content, in hex
+func (s *Signature) hash() (string, error) {
+	return mac([]byte(strings.Join([]string{s.Prev, s.Disclosure, s.Version, s.Digest,
+		s.Signer, s.Signed.UTC().Format(time.RFC3339Nano), s.Snapshot}, "\n")))
+}
+
+// Signature Print
+func (s *Signature) String() string {
+	return fmt.Sprintf("  %s (version %s) signed by %s on %s\n", s.Disclosure, s.Version, s.Signer,
+		s.Signed.Format("01/02/2006 15:04"))
+}
+
+// signedContent
+//
+// Part of the application its signatures vouch for: the applicant's
+// answers
+type signedContent struct {
+	Client          *Client      `json:"client"`
+	LoanType        loanType     `json:"loan-type"`
+	Refinance       *Refinance   `json:"refinance"`
+	Property        *Property    `json:"property,omitempty"`
+	LoanAmount      float64      `json:"loan-amount,omitempty"`
+	CoBorrow        []*Client    `json:"co-borrowers,omitempty"`
+	Assets          []*Asset     `json:"assets,omitempty"`
+	Liabilities     []*Liability `json:"liabilities,omitempty"`
+	ProposedPayment float64      `json:"proposed-payment,omitempty"`
+	CreditConsent   *time.Time   `json:"credit-consent,omitempty"`
+}
+
+// snapshot
+//
+// HMAC of the signed content of the application, in hex. Must be called
+// with the lock held.
+func (ctx *Context) snapshot() (string, error) {
+	buf, err := json.Marshal(&signedContent{
+		Client:          ctx.Client,
+		LoanType:        ctx.LoanType,
+		Refinance:       ctx.Refinance,
+		Property:        ctx.Property,
+		LoanAmount:      ctx.LoanAmount,
+		CoBorrow:        ctx.CoBorrow,
+		Assets:          ctx.Assets,
+		Liabilities:     ctx.Liabilities,
+		ProposedPayment: ctx.ProposedPayment,
+		CreditConsent:   ctx.CreditConsent,
+	})
+	if err != nil {
+		return "", err
+	}
+	return mac(buf)
+}
+
+// sign
+//
+// Record the signature of a disclosure by signer, chained to the last
+// one. Must be called with the lock held.
+func (ctx *Context) sign(d *Disclosure, signer string, now time.Time) (*Signature, error) {
+	snapshot, err := ctx.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	s := &Signature{Disclosure: d.Name, Version: d.Version, Digest: d.Digest(), Signer: signer,
+		Signed: now, Snapshot: snapshot}
+	if n := len(ctx.Signatures); n > 0 {
+		s.Prev = ctx.Signatures[n-1].Hash
+	}
+	if s.Hash, err = s.hash(); err != nil {
+		return nil, err
+	}
+	ctx.Signatures = append(ctx.Signatures, s)
+	return s, nil
+}
+
+// VerifySignatures
+//
+// Error when the signatures were tampered with: a signature altered,
+// removed or inserted, a disclosure whose text does not match its
+// version, or, once submitted, an application modified since its
+// submission was signed. The signatures cannot be verified without the
+// key they were signed with.
+func (ctx *Context) VerifySignatures() error {
+	ctx.Lock()
+	defer ctx.Unlock()
+	prev := ""
+	for i, s := range ctx.Signatures {
+		hash, err := s.hash()
+		if err != nil {
+			return err
+		}
+		d := disclosure(s.Disclosure, s.Version)
+		switch {
+		case s.Prev != prev:
+			return fmt.Errorf("signature #%d (%s) does not follow the previous one", i+1, s.Disclosure)
+		case !hmac.Equal([]byte(s.Hash), []byte(hash)):
+			return fmt.Errorf("signature #%d (%s) was modified", i+1, s.Disclosure)
+		case d == nil:
+			return fmt.Errorf("signature #%d: unknown disclosure %s version %s", i+1, s.Disclosure, s.Version)
+		case s.Digest != d.Digest():
+			return fmt.Errorf("signature #%d: text of disclosure %s version %s differs", i+1, s.Disclosure, s.Version)
+		}
+		prev = s.Hash
+	}
+
+	n := len(ctx.Signatures)
+	if ctx.Submitted == nil || n == 0 {
+		return nil
+	}
+	last := ctx.Signatures[n-1]
+	if last.Disclosure != "submission" {
+		return errors.New("the submission is not signed")
+	}
+	snapshot, err := ctx.snapshot()
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(snapshot), []byte(last.Snapshot)) {
+		return fmt.Errorf("the application was modified since it was signed on %s", last.Signed.Format("01/02/2006 15:04"))
+	}
+	return nil
+}
+
+// signaturesString
+//
+// Signatures of the application, and whether they are intact
+func (ctx *Context) signaturesString() string {
+	if len(ctx.Signatures) == 0 {
+		return ""
+	}
+	buff := &strings.Builder{}
+	buff.WriteString("\nSIGNATURES\n")
+	for _, s := range ctx.Signatures {
+		buff.WriteString(s.String())
+	}
+	if err := ctx.VerifySignatures(); errors.Is(err, errNoKey) {
+		buff.WriteString(fmt.Sprintf("  Not verified: %v\n", err))
+	} else if err != nil {
+		buff.WriteString(fmt.Sprintf("  TAMPERED: %v\n", err))
+	} else {
+		buff.WriteString("  Intact\n")
+	}
+	return buff.String()
+}
+
+// sameName
+//
+// Whether two names are the same, regardless of case and spacing
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
+
+// consentForm
+//
+// Questions of a disclosure, form name identifying them: the agreement,
+// and the signer's full name typed as signature
+func consentForm(name string, d *Disclosure, signer string) *Form {
+	agree := &Question{Path: "agree", Prompt: d.Prompt, Type: YESNO}
+	if d.Required {
+		agree.Check = func(data, value interface{}) error {
+			if !value.(bool) {
+				return errors.New("You must agree to go on with your application")
+			}
+			return nil
+		}
+	}
+	return &Form{Name: name, Questions: []*Question{
+		agree,
+		&Question{Path: "signature", Prompt: "Type your full name to sign:", Type: TEXT,
+			When: func(data interface{}) bool { return data.(*consentAnswer).Agree },
+			Check: func(data, value interface{}) error {
+				if !sameName(value.(string), signer) {
+					return errors.New("The signature must be your full name as given in your application")
+				}
+				return nil
+			}},
+	}}
+}
+
+// consentAnswer
+//
+// Answers to the questions of a disclosure
+type consentAnswer struct {
+	Agree     bool   `json:"agree"`
+	Signature string `json:"signature"`
+}
+
+// consent
+//
+// Present a disclosure and record the client's signature once they
+// agree, returning it, nil when they do not. name identifies the form.
+func (ctx *Context) consent(name string, d *Disclosure) (*Signature, error) {
+	ctx.Lock()
+	signer := ""
+	if ctx.Client != nil {
+		signer = ctx.Client.Name
+	}
+	ctx.Unlock()
+
+	ui := frontendOf(ctx.Run)
+	ui.Print(fmt.Sprintf("\n%s (version %s)\n\n%s\n\n", d.Title, d.Version, d.Text))
+	answer := &consentAnswer{}
+	if err := ui.Ask(consentForm(name, d, signer), answer, ctx.Run); err != nil || !answer.Agree {
+		return nil, err
+	}
+
+	ctx.Lock()
+	defer ctx.Unlock()
+	return ctx.sign(d, strings.Join(strings.Fields(answer.Signature), " "), time.Now())
+}
+
+// submissionConsent
+//
+// Have the client certify the application and sign it before it is
+// submitted
+func submissionConsent(ctx *Context) error {
+	_, err := ctx.consent("signature", disclosure("submission", ""))
+	return err
+}
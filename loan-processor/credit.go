@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Bureau
+//
+// Adapter to a credit bureau pulling the report of an applicant
+type Bureau interface {
+	Pull(req *CreditRequest) (*CreditReport, error)
+}
+
+// creditBureau is the bureau used by the `creditPull` task
+var creditBureau Bureau
+
+// errNoBureau is returned by `creditPull` when no bureau is configured
+var errNoBureau = errors.New("no credit bureau configured")
+
+// Attempts to pull a credit report before giving up
+const maxCreditAttempts = 8
+
+// Delay before pulling a credit report again, doubled after each attempt
+const creditBackoff = 15 * time.Minute
+
+// httpBureau
+//
+// Bureau reached over HTTP: the request is posted as JSON to
+// `<url>/reports`, which answers the report as JSON
+type httpBureau struct {
+	url    string
+	client *http.Client
+}
+
+// newHTTPBureau
+//
+// Bureau adapter for the service at url
+func newHTTPBureau(url string) Bureau {
+	return &httpBureau{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Pull
+//
+// Request the credit report of an applicant
+func (b *httpBureau) Pull(req *CreditRequest) (*CreditReport, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Post(b.url+"/reports", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("credit bureau: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("credit bureau: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	report := &CreditReport{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, fmt.Errorf("credit bureau: %v", err)
+	}
+	return report, nil
+}
+
+// Credit Print
+func (c *CreditReport) String() string {
+	t := c.Tradelines
+	return fmt.Sprintf("  Score: %d (%s, %s)\n", c.Score, c.Bureau, c.Pulled.Format("01/02/2006")) +
+		fmt.Sprintf("  Accounts: %d (%d open, %d delinquent)\n", t.Accounts, t.Open, t.Delinquent) +
+		fmt.Sprintf("  Balance: %s, %s/month\n", money(t.Balance), money(t.MonthlyPayments))
+}
+
+// creditString
+//
+// Summary of the credit report, which may still be pulled in background
+func (ctx *Context) creditString() string {
+	ctx.Lock()
+	consent, credit := ctx.CreditConsent, ctx.Credit
+	ctx.Unlock()
+
+	switch {
+	case consent == nil:
+		return ""
+	case credit == nil:
+		return "\nCREDIT REPORT\n  Pending, you will be notified of the result\n"
+	}
+	return "\nCREDIT REPORT\n" + credit.String()
+}
+
+// creditConsent
+//
+// Ask the client's authorization to pull their credit report, signed
+// under the credit disclosure, enabling `creditPull`
+func creditConsent(ctx *Context) error {
+	s, err := ctx.consent("creditConsent", disclosure("credit-authorization", ""))
+	if err != nil || s == nil {
+		return err
+	}
+
+	signed := s.Signed
+	ctx.Lock()
+	ctx.CreditConsent = &signed
+	ctx.Unlock()
+	return ctx.Enable("creditPull")
+}
+
+// creditPull
+//
+// Pull the client's credit report from the bureau. It runs in
+// background while the application goes on, so it does not prompt. A
+// failure does not fail the application: it is recorded, and the report
+// pulled again later by the `creditRetry` timer.
+func creditPull(ctx *Context) error {
+	return ctx.retryCredit(pullCredit(ctx), time.Now())
+}
+
+// creditRetry
+//
+// Timer task pulling the credit report again after a failure, unless the
+// application was declined meanwhile
+func creditRetry(ctx *Context) error {
+	ctx.Lock()
+	pulled := ctx.Credit != nil
+	ctx.Unlock()
+	if pulled || ctx.Status() == "declined" {
+		return nil
+	}
+	return ctx.retryCredit(pullCredit(ctx), time.Now())
+}
+
+// retryCredit
+//
+// Record the outcome of pulling the credit report. After a failure, the
+// `credit` timer retries it, until maxCreditAttempts.
+func (ctx *Context) retryCredit(err error, now time.Time) error {
+	ctx.Lock()
+	if err == nil {
+		ctx.CreditError = ""
+		ctx.Unlock()
+		ctx.Cancel("credit")
+		return nil
+	}
+	ctx.CreditAttempts++
+	ctx.CreditError = err.Error()
+	attempts := ctx.CreditAttempts
+	ctx.Unlock()
+
+	if attempts >= maxCreditAttempts {
+		log.Printf("application %s: credit report not pulled after %d attempts: %v", ctx.ID, attempts, err)
+		return nil
+	}
+	log.Printf("application %s: credit report not pulled, retrying: %v", ctx.ID, err)
+	return ctx.Schedule("credit", "creditRetry", now.Add(creditBackoff<<uint(attempts-1)))
+}
+
+// pullCredit
+//
+// Pull the client's credit report from the bureau into the application
+func pullCredit(ctx *Context) error {
+	if creditBureau == nil {
+		return errNoBureau
+	}
+
+	ctx.Lock()
+	req := &CreditRequest{Name: ctx.Client.Name, Age: ctx.Client.AgeOn(ctx.Created), Consent: *ctx.CreditConsent}
+	id := ctx.Client.TaxID
+	ctx.Unlock()
+	if id != nil {
+		var err error
+		if req.TaxID, err = id.Reveal(); err != nil {
+			return err
+		}
+	}
+
+	report, err := creditBureau.Pull(req)
+	if err != nil {
+		return err
+	}
+
+	ctx.Lock()
+	ctx.Credit = report
+	ctx.Unlock()
+	return nil
+}
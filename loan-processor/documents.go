@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Largest document accepted, in bytes
+const maxDocumentSize = 20 << 20
+
+// Content types accepted for documents
+var documentTypes = []string{"application/pdf", "image/jpeg", "image/png"}
+
+// Enum Print
+func (s docStatus) String() string {
+	switch s {
+	case MISSING:
+		return "missing"
+	case RECEIVED:
+		return "received"
+	case REJECTED:
+		return "rejected"
+	}
+	return "invalid"
+}
+
+// Document Print
+func (d *Document) String() string {
+	switch d.Status {
+	case RECEIVED:
+		return fmt.Sprintf("  %-9s %s (%s)\n", d.Status, d.Name, filepath.Base(d.File))
+	case REJECTED:
+		return fmt.Sprintf("  %-9s %s: %s\n", d.Status, d.Name, d.Reason)
+	}
+	return fmt.Sprintf("  %-9s %s\n", d.Status, d.Name)
+}
+
+// Outstanding
+//
+// Whether the document is still expected from the client
+func (d *Document) Outstanding() bool {
+	return d.Status != RECEIVED
+}
+
+// documentsDir
+//
+// Directory holding the documents' content, next to the applications. When
+// saving is disabled, a temporary directory created once.
+func documentsDir() string {
+	if dataDir != "" {
+		return filepath.Join(dataDir, "documents")
+	}
+	tempDocumentsOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "loan-processor-documents-")
+		if err != nil {
+			dir = filepath.Join(os.TempDir(), "loan-processor-documents")
+		}
+		tempDocuments = dir
+	})
+	return tempDocuments
+}
+
+var (
+	tempDocumentsOnce sync.Once
+	tempDocuments     string
+)
+
+// requiredDocuments
+//
+// Checklist of the documents required by the answers given so far
+func (ctx *Context) requiredDocuments(now time.Time) []*Document {
+	docs := []*Document{}
+	add := func(key, name string) {
+		docs = append(docs, &Document{Key: key, Name: name})
+	}
+
+	borrowers := []*Client{ctx.Client}
+	borrowers = append(borrowers, ctx.CoBorrow...)
+	for i, c := range borrowers {
+		if c == nil {
+			continue
+		}
+		key, whose := "client", ""
+		if i > 0 {
+			key, whose = fmt.Sprintf("co-borrower-%d", i), fmt.Sprintf(" (%s)", c.Name)
+		}
+		add(key+"/id", fmt.Sprintf("Government-issued photo ID%s", whose))
+
+		// Income of the employment history
+		since := now.AddDate(-historyYears, 0, 0)
+		kinds := map[employmentType]bool{}
+		for _, e := range c.Employment {
+			if e.Current() || e.End.After(since) {
+				kinds[e.Type] = true
+			}
+		}
+		if kinds[W2] {
+			add(key+"/paystubs", fmt.Sprintf("Pay stubs of the last 30 days%s", whose))
+			add(key+"/w2", fmt.Sprintf("W-2 forms of the last %d years%s", historyYears, whose))
+		}
+		if kinds[SELFEMPLOYED] {
+			add(key+"/tax-returns", fmt.Sprintf("Tax returns of the last %d years%s", historyYears, whose))
+			add(key+"/profit-loss", fmt.Sprintf("Year-to-date profit and loss statement%s", whose))
+		}
+		if kinds[RETIRED] {
+			add(key+"/retirement-income", fmt.Sprintf("Pension or social security award letter%s", whose))
+		}
+	}
+
+	switch ctx.LoanType {
+	case REFINANCE:
+		add("refinance/mortgage-statement", "Current mortgage statement")
+		add("refinance/insurance", "Homeowners insurance declaration page")
+	case PURCHASE:
+		add("purchase/contract", "Signed purchase contract")
+	}
+
+	for i, a := range ctx.Assets {
+		add(fmt.Sprintf("assets/%d/statement", i+1),
+			fmt.Sprintf("Last 2 monthly statements of %s %s account", a.Institution, a.Type))
+	}
+	return docs
+}
+
+// updateDocuments
+//
+// Derive the checklist from the answers, keeping the status of the
+// documents already attached
+func (ctx *Context) updateDocuments(now time.Time) {
+	current := map[string]*Document{}
+	for _, d := range ctx.Documents {
+		current[d.Key] = d
+	}
+	docs := ctx.requiredDocuments(now)
+	for i, d := range docs {
+		if prev, ok := current[d.Key]; ok {
+			prev.Name = d.Name
+			docs[i] = prev
+		}
+	}
+	ctx.Documents = docs
+}
+
+// Document
+//
+// Item of the checklist by key
+func (ctx *Context) Document(key string) (*Document, error) {
+	for _, d := range ctx.Documents {
+		if d.Key == key {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("no document '%s' required", key)
+}
+
+// documentsString
+//
+// Summary of the documents still expected
+func (ctx *Context) documentsString() string {
+	buff := &bytes.Buffer{}
+	for _, d := range ctx.Documents {
+		if d.Outstanding() {
+			buff.WriteString(d.String())
+		}
+	}
+	if len(ctx.Documents) == 0 {
+		return ""
+	}
+	if buff.Len() == 0 {
+		return "\nDOCUMENTS\n  All required documents were received\n"
+	}
+	return "\nOUTSTANDING DOCUMENTS\n" + buff.String()
+}
+
+// storeDocument
+//
+// Copy the file at path to the documents' directory under its SHA-256
+// hash, so the same content is stored once
+func storeDocument(path string) (hash string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	dir := documentsDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	sum := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, sum), io.LimitReader(f, maxDocumentSize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if size > maxDocumentSize {
+		return "", 0, fmt.Errorf("file is larger than %d MB", maxDocumentSize>>20)
+	}
+
+	hash = hex.EncodeToString(sum.Sum(nil))
+	target := filepath.Join(dir, hash[:2], hash)
+	if _, err := os.Stat(target); err == nil {
+		return hash, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return "", 0, err
+	}
+	return hash, size, os.Rename(tmp.Name(), target)
+}
+
+// documentType
+//
+// Content type of a stored document, from its first bytes
+func documentType(hash string) (string, error) {
+	return contentType(filepath.Join(documentsDir(), hash[:2], hash))
+}
+
+// contentType
+//
+// Content type of the file at path, from its first bytes
+func contentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := f.Read(buf)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// supportedType
+//
+// Whether documents of a content type are accepted
+func supportedType(contentType string) bool {
+	for _, t := range documentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFile
+//
+// Error when the file at path cannot be stored as a document
+func checkFile(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return err
+	case info.IsDir():
+		return fmt.Errorf("'%s' is a directory", path)
+	case info.Size() == 0:
+		return fmt.Errorf("'%s' is empty", path)
+	}
+	return nil
+}
+
+// documentFileCheck
+//
+// Check of the path of a file answered for a document: a file which
+// can be stored, of a supported type
+func documentFileCheck(data, value interface{}) error {
+	path := value.(string)
+	if err := checkFile(path); err != nil {
+		return err
+	}
+	t, err := contentType(path)
+	if err != nil {
+		return err
+	}
+	if !supportedType(t) {
+		return fmt.Errorf("unsupported file type %s", t)
+	}
+	return nil
+}
+
+// Attach
+//
+// Store the file at path as the document key. A document of an
+// unsupported type is recorded as rejected.
+func (d *Document) Attach(path string, now time.Time) error {
+	if err := checkFile(path); err != nil {
+		return err
+	}
+
+	hash, size, err := storeDocument(path)
+	if err != nil {
+		return err
+	}
+	contentType, err := documentType(hash)
+	if err != nil {
+		return err
+	}
+
+	d.File, d.Hash, d.Size, d.Received = filepath.Base(path), hash, size, &now
+	d.Status, d.Reason = RECEIVED, ""
+	if !supportedType(contentType) {
+		d.Status, d.Reason = REJECTED, fmt.Sprintf("unsupported file type %s", contentType)
+	}
+	return nil
+}
+
+// Reject
+//
+// Record why a received document is not acceptable
+func (d *Document) Reject(reason string) error {
+	if d.Status != RECEIVED {
+		return fmt.Errorf("document '%s' is %s", d.Key, d.Status)
+	}
+	if reason == "" {
+		return errors.New("a reason is required")
+	}
+	d.Status, d.Reason = REJECTED, reason
+	return nil
+}
+
+// documents
+//
+// Derive the required documents from the answers and ask the client to
+// attach those still outstanding, on front ends where files are attached
+// by their path. Elsewhere the client acknowledges the checklist, the
+// files being attached later with the `docs` command.
+func documents(ctx *Context) error {
+	ctx.Lock()
+	ctx.updateDocuments(time.Now())
+	docs := []*Document{}
+	for _, d := range ctx.Documents {
+		if d.Outstanding() {
+			docs = append(docs, d)
+		}
+	}
+	ctx.Unlock()
+	if len(docs) == 0 {
+		return nil
+	}
+	ui := frontendOf(ctx.Run)
+	if u, ok := ui.(uploader); !ok || !u.uploads() {
+		checklist := "\nPlease provide the following documents (PDF, JPEG or PNG) once your\n" +
+			"application is submitted, your loan officer will tell you how:\n\n"
+		for _, d := range docs {
+			checklist += "  " + d.Name + "\n"
+		}
+		ui.Print(checklist)
+		return ui.Ask(&Form{Name: "documents", Title: "Documents"}, &struct{}{}, ctx)
+	}
+
+	ui.Print("\nPlease provide the following documents (PDF, JPEG or PNG).\n" +
+		"Leave empty to provide them later.\n\n")
+
+	for _, d := range docs {
+		answer := &struct {
+			File string `json:"file"`
+		}{}
+		form := &Form{Name: "documents", Questions: []*Question{
+			&Question{Path: "file", Prompt: d.Name + ", path of the file?", Type: TEXT, Optional: true,
+				Check: documentFileCheck},
+		}}
+		if err := ui.Ask(form, answer, ctx); err != nil {
+			return err
+		}
+		if answer.File == "" {
+			continue
+		}
+		ctx.Lock()
+		err := d.Attach(answer.File, time.Now())
+		ctx.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// docs
+//
+// `docs` command: show the document checklist of a saved application,
+// attach a file to an item or reject a received one
+func docs(args []string) error {
+	flags := flag.NewFlagSet("docs", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	appID := flags.String("app", "", "saved application")
+	attach := flags.String("attach", "", "attach a file to a document: <key>=<path>")
+	reject := flags.String("reject", "", "reject a received document: <key>")
+	reason := flags.String("reason", "", "reason of the rejection")
+	flags.Parse(args)
+
+	if *appID == "" {
+		return errors.New("an application is required (-app)")
+	}
+	ctx, err := loadContext(*appID)
+	if err != nil {
+		return err
+	}
+	ctx.updateDocuments(time.Now())
+
+	switch {
+	case *attach != "":
+		parts := strings.SplitN(*attach, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid attachment '%s', expected <key>=<path>", *attach)
+		}
+		d, err := ctx.Document(parts[0])
+		if err != nil {
+			return err
+		}
+		if err := d.Attach(parts[1], time.Now()); err != nil {
+			return err
+		}
+	case *reject != "":
+		d, err := ctx.Document(*reject)
+		if err != nil {
+			return err
+		}
+		if err := d.Reject(*reason); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Save(); err != nil {
+		return err
+	}
+
+	for _, d := range ctx.Documents {
+		fmt.Printf("%-32s %s", d.Key, d.String())
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Deliveries of a message attempted before giving up
+const maxMailAttempts = 8
+
+// Delay before retrying a message, doubled after each attempt
+const mailBackoff = time.Minute
+
+// confirmationTemplate renders the confirmation of an application. It
+// defines the "subject" and "body" of the message.
+const confirmationTemplate = `{{define "subject"}}Your loan application {{.ID}}{{end}}
+{{- define "body"}}Dear {{.Client.Name}},
+
+Thank you for your {{.LoanType}} loan application. Your application ID is
+{{.ID}}, please mention it when contacting us.
+{{.Summary}}
+NEXT STEPS
+{{- if .Outstanding}}
+Please send us the {{len .Outstanding}} outstanding document(s) listed above.
+{{- else}}
+We have received all the documents we need.
+{{- end}}
+A loan officer will review your application and contact you shortly.
+{{end}}`
+
+// mailer
+//
+// SMTP server sending the messages, and the template they are rendered with.
+// The server's certificate is verified against roots, the system's when nil.
+type mailer struct {
+	addr     string
+	from     string
+	user     string
+	password string
+	template *template.Template
+	roots    *x509.CertPool
+}
+
+// emailer sends the emails, nil when email is disabled
+var emailer *mailer
+
+// mailFlags
+//
+// Register the SMTP settings on a command's flags. The returned function
+// configures `emailer` once the flags are parsed. The password is read from
+// the SMTP_PASSWORD environment variable to keep it off the command line.
+func mailFlags(flags *flag.FlagSet) func() error {
+	addr := flags.String("smtp", "", "SMTP server <host>:<port> sending emails, empty to disable")
+	from := flags.String("mail-from", "loans@localhost", "sender of emails")
+	user := flags.String("smtp-user", "", "SMTP user; the password is read from SMTP_PASSWORD")
+	tmpl := flags.String("mail-template", "", "template of the confirmation email, empty for the default")
+
+	return func() error {
+		if *addr == "" {
+			return nil
+		}
+		text := confirmationTemplate
+		if *tmpl != "" {
+			buf, err := ioutil.ReadFile(*tmpl)
+			if err != nil {
+				return err
+			}
+			text = string(buf)
+		}
+		t, err := template.New("confirmation").Funcs(template.FuncMap{"money": money}).Parse(text)
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"subject", "body"} {
+			if t.Lookup(name) == nil {
+				return fmt.Errorf("email template has no '%s'", name)
+			}
+		}
+		emailer = &mailer{addr: *addr, from: *from, user: *user, password: os.Getenv("SMTP_PASSWORD"), template: t}
+		return nil
+	}
+}
+
+// Message
+//
+// Email waiting in the outbox
+type Message struct {
+	ID          string    `json:"id"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Subject     string    `json:"subject"`
+	Body        string    `json:"body"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next-attempt"`
+	LastError   string    `json:"last-error,omitempty"`
+}
+
+// outboxDir
+//
+// Directory of the messages not sent yet
+func outboxDir() string {
+	return filepath.Join(dataDir, "outbox")
+}
+
+// GivenUp
+//
+// Whether no further delivery of the message is attempted
+func (m *Message) GivenUp() bool {
+	return m.Attempts >= maxMailAttempts
+}
+
+// save
+//
+// Write the message to the outbox
+func (m *Message) save() error {
+	if err := os.MkdirAll(outboxDir(), 0700); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(outboxDir(), m.ID+".json")
+	if err := ioutil.WriteFile(path+".tmp", buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// raw
+//
+// Message in RFC 5322 format
+func (m *Message) raw() []byte {
+	buff := &bytes.Buffer{}
+	buff.WriteString(fmt.Sprintf("From: %s\r\n", m.From))
+	buff.WriteString(fmt.Sprintf("To: %s\r\n", m.To))
+	buff.WriteString(fmt.Sprintf("Subject: %s\r\n", m.Subject))
+	buff.WriteString(fmt.Sprintf("Date: %s\r\n", m.Created.Format(time.RFC1123Z)))
+	buff.WriteString(fmt.Sprintf("Message-ID: <%s@loan-processor>\r\n", m.ID))
+	buff.WriteString("MIME-Version: 1.0\r\n")
+	buff.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	buff.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return buff.Bytes()
+}
+
+// send
+//
+// Deliver a message to the SMTP server
+func (ml *mailer) send(m *Message) error {
+	conn, err := net.DialTimeout("tcp", ml.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	host, _, _ := net.SplitHostPort(ml.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host, RootCAs: ml.roots}); err != nil {
+			return err
+		}
+	}
+	if ml.user != "" {
+		if err := c.Auth(smtp.PlainAuth("", ml.user, ml.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.raw()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// deliver
+//
+// Attempt to send a message of the outbox. It is removed once sent, or
+// scheduled for another attempt.
+func (ml *mailer) deliver(m *Message, now time.Time) error {
+	err := ml.send(m)
+	if err == nil {
+		return os.Remove(filepath.Join(outboxDir(), m.ID+".json"))
+	}
+
+	m.Attempts++
+	m.LastError = err.Error()
+	m.NextAttempt = now.Add(mailBackoff << uint(m.Attempts-1))
+	if serr := m.save(); serr != nil {
+		return serr
+	}
+	return err
+}
+
+// queue
+//
+// Place a message in the outbox and attempt to send it
+func (ml *mailer) queue(to, subject, body string, now time.Time) (*Message, error) {
+	m := &Message{
+		ID:          newID(),
+		From:        ml.from,
+		To:          to,
+		Subject:     subject,
+		Body:        body,
+		Created:     now,
+		NextAttempt: now,
+	}
+	if err := m.save(); err != nil {
+		return nil, err
+	}
+	return m, ml.deliver(m, now)
+}
+
+// loadOutbox
+//
+// Messages of the outbox, oldest first
+func loadOutbox() ([]*Message, error) {
+	files, err := ioutil.ReadDir(outboxDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []*Message{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(outboxDir(), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := &Message{}
+		if err := json.Unmarshal(buf, m); err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name(), err)
+		}
+		msgs = append(msgs, m)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Created.Before(msgs[j].Created)
+	})
+	return msgs, nil
+}
+
+// flush
+//
+// Retry the messages of the outbox which are due, returning how many
+// were sent
+func (ml *mailer) flush(now time.Time) (int, error) {
+	msgs, err := loadOutbox()
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, m := range msgs {
+		if m.GivenUp() || now.Before(m.NextAttempt) {
+			continue
+		}
+		if err := ml.deliver(m, now); err != nil {
+			log.Printf("email %s to %s: %v", m.ID, m.To, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// confirmationData
+//
+// Fields available to the confirmation template
+type confirmationData struct {
+	*Context
+	Summary     string
+	Outstanding []*Document
+}
+
+// render
+//
+// Subject and body of the confirmation of an application
+func (ml *mailer) render(ctx *Context) (subject, body string, err error) {
+	data := &confirmationData{Context: ctx, Summary: ctx.String()}
+	for _, d := range ctx.Documents {
+		if d.Outstanding() {
+			data.Outstanding = append(data.Outstanding, d)
+		}
+	}
+
+	buff := &bytes.Buffer{}
+	if err := ml.template.ExecuteTemplate(buff, "subject", data); err != nil {
+		return "", "", err
+	}
+	subject = strings.TrimSpace(buff.String())
+	buff.Reset()
+	if err := ml.template.ExecuteTemplate(buff, "body", data); err != nil {
+		return "", "", err
+	}
+	return subject, buff.String(), nil
+}
+
+// confirmation
+//
+// Email the confirmation of the application to the client. A failed
+// delivery stays in the outbox to be retried, and does not fail the
+// application.
+func confirmation(ctx *Context) error {
+	if emailer == nil || ctx.Client == nil || ctx.Client.Email == "" {
+		return nil
+	}
+	subject, body, err := emailer.render(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := emailer.queue(ctx.Client.Email, subject, body, time.Now()); err != nil {
+		log.Printf("confirmation to %s: %v, it will be sent later", ctx.Client.Email, err)
+		return nil
+	}
+	frontendOf(ctx.Run).Print(fmt.Sprintf("\nA confirmation was sent to %s\n", ctx.Client.Email))
+	return nil
+}
+
+// outbox
+//
+// `outbox` command: list the emails not sent yet, or retry those due
+func outbox(args []string) error {
+	flags := flag.NewFlagSet("outbox", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	configure := mailFlags(flags)
+	send := flags.Bool("send", false, "retry the emails which are due")
+	flags.Parse(args)
+	if err := configure(); err != nil {
+		return err
+	}
+
+	if *send {
+		if emailer == nil {
+			return errors.New("an SMTP server is required (-smtp)")
+		}
+		sent, err := emailer.flush(time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d email(s) sent\n", sent)
+	}
+
+	msgs, err := loadOutbox()
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		next := m.NextAttempt.Format(time.RFC3339)
+		if m.GivenUp() {
+			next = "given up"
+		}
+		fmt.Printf("%s  %-30s  %d attempt(s), next: %s, %s\n", m.ID, m.To, m.Attempts, next, m.LastError)
+	}
+	return nil
+}
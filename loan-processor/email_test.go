@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer
+//
+// Fake SMTP server offering STARTTLS, recording the message it receives
+// and whether it was sent over TLS
+type smtpServer struct {
+	ln     net.Listener
+	config *tls.Config
+	done   chan struct{}
+	data   string
+	tls    bool
+}
+
+// newSMTPServer
+//
+// Start a fake server with a certificate for names, returning the pool
+// of roots trusting it
+func newSMTPServer(t *testing.T, names ...string) (*smtpServer, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake smtp"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{
+		ln:     ln,
+		config: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		done:   make(chan struct{}),
+	}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s, roots
+}
+
+// serve
+//
+// Hold the conversation of a single client
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch {
+		case verb == "EHLO" && !s.tls:
+			tp.PrintfLine("250-fake\r\n250 STARTTLS")
+		case verb == "EHLO":
+			tp.PrintfLine("250 fake")
+		case verb == "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tc := tls.Server(conn, s.config)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			conn, s.tls = tc, true
+			tp = textproto.NewConn(conn)
+		case verb == "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			tp.PrintfLine("250 queued")
+		case verb == "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func TestSendStartTLS(t *testing.T) {
+	s, roots := newSMTPServer(t, "127.0.0.1")
+	ml := &mailer{addr: s.ln.Addr().String(), from: "loans@example.com", roots: roots}
+	m := &Message{ID: "1", From: ml.from, To: "jane@example.com", Subject: "Hello", Body: "Welcome", Created: time.Now()}
+	if err := ml.send(m); err != nil {
+		t.Fatal(err)
+	}
+	<-s.done
+	if !s.tls {
+		t.Error("message sent without STARTTLS")
+	}
+	if !strings.Contains(s.data, "Subject: Hello") || !strings.Contains(s.data, "Welcome") {
+		t.Errorf("message not received, got %q", s.data)
+	}
+}
+
+func TestSendStartTLSWrongHost(t *testing.T) {
+	s, roots := newSMTPServer(t, "mail.example.com")
+	ml := &mailer{addr: s.ln.Addr().String(), from: "loans@example.com", roots: roots}
+	m := &Message{ID: "1", From: ml.from, To: "jane@example.com", Subject: "Hello", Body: "Welcome", Created: time.Now()}
+	if err := ml.send(m); err == nil {
+		t.Fatal("certificate of another host accepted")
+	}
+	<-s.done
+	if s.data != "" {
+		t.Error("message sent to a server failing verification")
+	}
+}
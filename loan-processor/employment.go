@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// Employment history must cover this many years
+const historyYears = 2
+
+// Monthly gross income accepted for a single employment
+const maxMonthlyIncome = 1000000
+
+// Enum Print
+func (e employmentType) String() string {
+	switch e {
+	case W2:
+		return "W-2"
+	case SELFEMPLOYED:
+		return "self-employed"
+	case RETIRED:
+		return "retired"
+	}
+	return "invalid"
+}
+
+// Current
+//
+// Whether the employment has not ended
+func (e *Employment) Current() bool {
+	return e.End == nil
+}
+
+// Employment Print
+func (e *Employment) String() string {
+	buff := &bytes.Buffer{}
+	switch e.Type {
+	case RETIRED:
+		buff.WriteString(fmt.Sprintf("    Retired: since %s\n", e.Start.Format("01/2006")))
+	default:
+		buff.WriteString(fmt.Sprintf("   Employer: %s (%s)\n", e.Employer, e.Type))
+		buff.WriteString(fmt.Sprintf("   Position: %s\n", e.Position))
+		end := "present"
+		if e.End != nil {
+			end = e.End.Format("01/2006")
+		}
+		buff.WriteString(fmt.Sprintf("     Period: %s - %s\n", e.Start.Format("01/2006"), end))
+	}
+	buff.WriteString(fmt.Sprintf("     Income: %s/month\n", money(e.MonthlyIncome)))
+	return buff.String()
+}
+
+// MonthlyIncome
+//
+// Monthly gross income of the client's current employments
+func (c *Client) MonthlyIncome() float64 {
+	income := 0.0
+	for _, e := range c.Employment {
+		if e.Current() {
+			income += e.MonthlyIncome
+		}
+	}
+	return income
+}
+
+// MonthlyIncome
+//
+// Monthly gross income of the client and co-borrowers
+func (ctx *Context) MonthlyIncome() float64 {
+	income := 0.0
+	if ctx.Client != nil {
+		income += ctx.Client.MonthlyIncome()
+	}
+	for _, c := range ctx.CoBorrow {
+		income += c.MonthlyIncome()
+	}
+	return income
+}
+
+// Item
+//
+// Element of a list collected by a repeated step: "employment"
+func (c *Client) Item(path string, index int) (interface{}, error) {
+	switch path {
+	case "employment":
+		if index == len(c.Employment) {
+			c.Employment = append(c.Employment, &Employment{})
+		}
+		if index < 0 || index >= len(c.Employment) {
+			return nil, fmt.Errorf("no employment #%d", index+1)
+		}
+		return c.Employment[index], nil
+	}
+	return nil, fmt.Errorf("invalid list '%s'", path)
+}
+
+// Truncate
+//
+// Keep the first n elements of a list collected by a repeated step
+func (c *Client) Truncate(path string, n int) error {
+	switch path {
+	case "employment":
+		if n < len(c.Employment) {
+			c.Employment = c.Employment[:n]
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid list '%s'", path)
+}
+
+// covered
+//
+// Whether the employments cover the last `years` years without gap
+func (c *Client) covered(years int, now time.Time) bool {
+	jobs := make([]*Employment, 0, len(c.Employment))
+	for _, e := range c.Employment {
+		if e.Type == RETIRED {
+			return true
+		}
+		if !e.Start.IsZero() {
+			jobs = append(jobs, e)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Start.Before(jobs[j].Start)
+	})
+
+	// Sweep the periods from the oldest, one month of gap is tolerated
+	reach := now.AddDate(-years, 0, 0)
+	for _, e := range jobs {
+		if e.Start.After(reach.AddDate(0, 1, 0)) {
+			return false
+		}
+		end := now
+		if e.End != nil {
+			end = *e.End
+		}
+		if end.After(reach) {
+			reach = end
+		}
+	}
+	return !reach.Before(now.AddDate(0, -1, 0))
+}
+
+// pastMonth
+//
+// Check that a month answered is not in the future
+func pastMonth(data, value interface{}) error {
+	if value.(time.Time).After(time.Now()) {
+		return errors.New("Date is in the future")
+	}
+	return nil
+}
+
+// employmentForm
+//
+// Questions collecting an employment of a client, current or previous,
+// whose start must follow the client's 14th birthday
+func employmentForm(borrower string, current bool, client *Client) *Form {
+	typeMsg := fmt.Sprintf("What is %s current employment?", borrower)
+	if !current {
+		typeMsg = fmt.Sprintf("What was %s previous employment?", borrower)
+	}
+	is := func(types ...employmentType) func(data interface{}) bool {
+		return func(data interface{}) bool {
+			for _, t := range types {
+				if data.(*Employment).Type == t {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	working := is(W2, SELFEMPLOYED)
+
+	return &Form{Name: "employment", Questions: []*Question{
+		&Question{Path: "type", Prompt: typeMsg, Type: CHOICE,
+			Options: []string{"W-2 employee", "Self-employed", "Retired"}},
+
+		// Retirement
+		&Question{Path: "start-date", Prompt: "Since when", Type: DATE, Check: pastMonth, When: is(RETIRED)},
+		&Question{Path: "monthly-income", Prompt: "What is the monthly retirement income?", Type: MONEY,
+			Optional: true, Max: maxMonthlyIncome, When: is(RETIRED)},
+
+		// Employer & Position
+		&Question{Path: "employer", Prompt: "What is the employer's name?", Type: TEXT,
+			Invalid: "Invalid name", When: is(W2)},
+		&Question{Path: "employer", Prompt: "What is the business name?", Type: TEXT,
+			Invalid: "Invalid name", When: is(SELFEMPLOYED)},
+		&Question{Path: "position", Prompt: "What is the position?", Type: TEXT,
+			Invalid: "Invalid position", When: working},
+
+		// Start & End Dates
+		&Question{Path: "start-date", Prompt: "What is the start date", Type: DATE, When: working,
+			Check: func(data, value interface{}) error {
+				if err := pastMonth(data, value); err != nil {
+					return err
+				}
+				if client.Birth != nil && value.(time.Time).Before(client.Birth.AddDate(14, 0, 0)) {
+					return errors.New("Start date is before the age of 14")
+				}
+				return nil
+			}},
+		&Question{Path: "end-date", Prompt: "What is the end date, empty if current", Type: DATE,
+			Optional: true, When: func(data interface{}) bool { return !current && working(data) },
+			Check: func(data, value interface{}) error {
+				if err := pastMonth(data, value); err != nil {
+					return err
+				}
+				if value.(time.Time).Before(data.(*Employment).Start) {
+					return errors.New("End date is before the start date")
+				}
+				return nil
+			}},
+
+		// Income
+		&Question{Path: "monthly-income", Prompt: "What is the monthly gross income?", Type: MONEY,
+			Max: maxMonthlyIncome, When: working},
+	}}
+}
+
+// employment
+//
+// Collect an employment of the client or co-borrower in scope
+func employment(r *workflow.Run) error {
+	job, ok := r.Data.(*Employment)
+	if !ok {
+		return fmt.Errorf("no employment in scope '%s'", r.Scope)
+	}
+	client, ok := r.Parent().Data.(*Client)
+	if !ok {
+		return fmt.Errorf("no client in scope '%s'", r.Parent().Scope)
+	}
+	current := len(client.Employment) > 0 && client.Employment[0] == job
+
+	borrower := "your"
+	if strings.HasPrefix(r.Scope, "co-borrowers") {
+		borrower = "your co-borrower's"
+	}
+	r.Lock()
+	*job = Employment{}
+	r.Unlock()
+	return frontendOf(r).Ask(employmentForm(borrower, current, client), job, r)
+}
+
+// moreEmployment
+//
+// End the employment history once it covers two years, or when there
+// is no other employment
+func moreEmployment(r *workflow.Run) error {
+	client, ok := r.Parent().Data.(*Client)
+	if !ok {
+		return fmt.Errorf("no client in scope '%s'", r.Parent().Scope)
+	}
+	if client.covered(historyYears, time.Now()) {
+		r.Break()
+		return nil
+	}
+
+	msg := fmt.Sprintf("Is there another employment in the past %d years?", historyYears)
+	return more("moreEmployment", msg)(r)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// scripted
+//
+// Front end answering the questions from a script and recording the
+// conversation: the prompts along with the answers, those of secret
+// questions masked
+type scripted struct {
+	in     *bufio.Scanner
+	out    *bytes.Buffer
+	reader *echoReader
+}
+
+// newScripted
+//
+// Front end answering with the lines of script, one answer per line
+func newScripted(lines []string) *scripted {
+	s := &scripted{out: &bytes.Buffer{}}
+	s.reader = &echoReader{lines: lines, out: s.out}
+	s.in = bufio.NewScanner(s.reader)
+	return s
+}
+
+func (s *scripted) Ask(form *Form, data interface{}, lock sync.Locker) error {
+	return form.Ask(s.in, s, data, lock)
+}
+
+func (s *scripted) Print(text string) {
+	s.out.WriteString(text)
+}
+
+func (s *scripted) Write(p []byte) (int, error) {
+	return s.out.Write(p)
+}
+
+func (s *scripted) echo(on bool) {
+	s.reader.hidden = !on
+}
+
+func (s *scripted) uploads() bool {
+	return true
+}
+
+// echoReader
+//
+// Reader handing out a line of the script at a time, as the scanner
+// needs it, and echoing it after the prompt it answers, masked when
+// hidden
+type echoReader struct {
+	lines  []string
+	out    io.Writer
+	hidden bool
+}
+
+func (r *echoReader) Read(p []byte) (int, error) {
+	if len(r.lines) == 0 {
+		return 0, io.EOF
+	}
+	line := r.lines[0] + "\n"
+	if len(line) > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	r.lines = r.lines[1:]
+	if r.hidden {
+		io.WriteString(r.out, "********\n")
+	} else {
+		io.WriteString(r.out, line)
+	}
+	return copy(p, line), nil
+}
+
+var (
+	// Timestamps of the application JSON
+	timestampRE = regexp.MustCompile(`"\d{4}-\d\d-\d\dT[0-9:.]+(Z|[+-]\d\d:\d\d)"`)
+	// Month relative to the run in a script, e.g. {month-6} six months ago
+	monthRE = regexp.MustCompile(`\{month-(\d+)\}`)
+	// Hashes of the signatures, covering times and sealed tax IDs
+	signatureHashRE = regexp.MustCompile(`"(snapshot|prev|hash)": "[0-9a-f]{64}"`)
+)
+
+// expandMonths
+//
+// Script with its relative months replaced by the month they stand for,
+// in MM/YYYY format, along with the month of each
+func expandMonths(script []string, start time.Time) ([]string, map[string]time.Time) {
+	months := map[string]time.Time{}
+	lines := make([]string, len(script))
+	for i, line := range script {
+		lines[i] = monthRE.ReplaceAllStringFunc(line, func(s string) string {
+			n, _ := strconv.Atoi(monthRE.FindStringSubmatch(s)[1])
+			month := time.Date(start.Year(), start.Month()-time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+			months[s] = month
+			return month.Format("01/2006")
+		})
+	}
+	return lines, months
+}
+
+// masked
+//
+// Transcript or application JSON without what changes from a run to the
+// next: the times around start, when the run took place, the months
+// relative to it, shown as in the script, and the hashes of the
+// signatures
+func masked(text string, start time.Time, months map[string]time.Time) string {
+	for s, month := range months {
+		text = strings.Replace(text, month.Format("01/2006"), s, -1)
+		text = strings.Replace(text, `"`+month.Format(time.RFC3339)+`"`, `"`+s+`"`, -1)
+	}
+	text = timestampRE.ReplaceAllStringFunc(text, func(s string) string {
+		t, err := time.Parse(time.RFC3339Nano, strings.Trim(s, `"`))
+		if err != nil || t.Sub(start) > time.Hour || start.Sub(t) > time.Hour {
+			return s
+		}
+		return `"<now>"`
+	})
+	for _, layout := range []string{"01/02/2006", "2006-01-02"} {
+		text = strings.Replace(text, start.Format(layout), "<today>", -1)
+	}
+	return signatureHashRE.ReplaceAllString(text, `"$1": "<sha256>"`)
+}
+
+// replay
+//
+// Run workflow answering with the lines of script, returning the
+// transcript of the conversation and the resulting application in JSON
+func replay(workName, id string, script []string) (transcript, app []byte, err error) {
+	start := time.Now()
+	ctx, err := newContext(workName)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx.ID, ctx.Serial = id, true
+	script, months := expandMonths(script, start)
+	ui := newScripted(script)
+	ctx.ui = ui
+
+	// The end of the script is where the applicant leaves
+	if err := ctx.Execute(); err != nil && !errors.Is(err, workflow.ErrWaiting) {
+		fmt.Fprintf(ui.out, "\n[workflow ended: %v]\n", err)
+	}
+	// Sealed tax IDs change from a run to the next
+	buf, err := json.MarshalIndent(ctx.Export(), "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(masked(ui.out.String(), start, months)), []byte(masked(string(buf)+"\n", start, months)), nil
+}
+
+// readScript
+//
+// Answers of a script file, one per line. Lines starting with "#" are
+// comments, empty lines are empty answers. Dates which must stay recent
+// are given relative to the run, e.g. {month-6} for six months ago.
+func readScript(path string) ([]string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, strings.TrimSuffix(line, "\r"))
+		}
+	}
+	return lines, nil
+}
+
+// firstDiff
+//
+// First line where got differs from want, empty when they are equal
+func firstDiff(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	w, g := strings.Split(string(want), "\n"), strings.Split(string(got), "\n")
+	for i := 0; ; i++ {
+		if i >= len(w) || i >= len(g) || w[i] != g[i] {
+			line := func(lines []string) string {
+				if i >= len(lines) {
+					return "<end>"
+				}
+				return fmt.Sprintf("%q", lines[i])
+			}
+			return fmt.Sprintf("line %d\n    want: %s\n    got:  %s", i+1, line(w), line(g))
+		}
+	}
+}
+
+// Write the golden files instead of comparing them with the replays
+var update = flag.Bool("update", false, "write the golden files of TestGolden")
+
+// TestGolden
+//
+// Replay the scripts `testdata/golden/<name>.in` through `newAccount` and
+// compare the transcript and the application with the golden files
+// `<name>.out` and `<name>.json`, or write them with -update
+func TestGolden(t *testing.T) {
+	// Replays must not depend on the environment they run in
+	offline()
+
+	scripts, err := filepath.Glob(filepath.Join("testdata", "golden", "*.in"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no script in testdata/golden")
+	}
+	for _, path := range scripts {
+		name := strings.TrimSuffix(filepath.Base(path), ".in")
+		t.Run(name, func(t *testing.T) {
+			script, err := readScript(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transcript, app, err := replay("newAccount", name, script)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			base := strings.TrimSuffix(path, ".in")
+			outputs := []struct {
+				ext string
+				got []byte
+			}{{".out", transcript}, {".json", app}}
+			for _, o := range outputs {
+				if *update {
+					if err := ioutil.WriteFile(base+o.ext, o.got, 0644); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := ioutil.ReadFile(base + o.ext)
+				if err != nil {
+					t.Fatalf("%v, run with -update to create it", err)
+				}
+				if diff := firstDiff(want, o.got); diff != "" {
+					t.Errorf("%s differs at %s", o.ext, diff)
+				}
+			}
+		})
+	}
+}
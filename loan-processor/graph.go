@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// graph
+//
+// `graph` command: render a workflow as a DOT or Mermaid diagram,
+// optionally overlaying the path taken by a saved application
+func graph(args []string) error {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	workName := flags.String("workflow", "newAccount", "workflow to render")
+	format := flags.String("format", "dot", "diagram format: dot or mermaid")
+	appID := flags.String("app", "", "saved application to overlay")
+	flags.Parse(args)
+
+	var run *workflow.Run
+	if *appID != "" {
+		ctx, err := loadContext(*appID)
+		if err != nil {
+			return err
+		}
+		*workName, run = ctx.WorkFlow, ctx.Run
+	}
+
+	switch *format {
+	case "dot":
+		return workflow.WriteDOT(os.Stdout, *workName, run)
+	case "mermaid":
+		return workflow.WriteMermaid(os.Stdout, *workName, run)
+	}
+	return fmt.Errorf("invalid format '%s'", *format)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// errNoKey is returned when a key of the server is not configured
+var errNoKey = errors.New("no key")
+
+// serverKey
+//
+// Secret key of the server, e.g. sealing the tax IDs, loaded once from
+// the environment: env holds the key itself, 32 bytes in base64, or
+// fileEnv the path of the file holding it, created on first use. The
+// file must be outside the data directory, so that reading the
+// applications is not enough to use the key. When saving is disabled, a
+// key is drawn and only kept in memory.
+type serverKey struct {
+	sync.Mutex
+	use     string // what the key is for
+	env     string
+	fileEnv string
+	key     []byte
+}
+
+// load
+//
+// Key once loaded, or created as needed
+func (k *serverKey) load() ([]byte, error) {
+	k.Lock()
+	defer k.Unlock()
+	if k.key != nil {
+		return k.key, nil
+	}
+
+	var key []byte
+	var err error
+	path := os.Getenv(k.fileEnv)
+	switch {
+	case os.Getenv(k.env) != "":
+		key, err = base64.StdEncoding.DecodeString(os.Getenv(k.env))
+		if err == nil && len(key) != 32 {
+			err = errors.New("not 32 bytes")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", k.env, err)
+		}
+	case path != "":
+		if within(path, dataDir) {
+			return nil, fmt.Errorf("%s: %s must be outside the data directory %s", k.fileEnv, path, dataDir)
+		}
+		key, err = ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			key = make([]byte, 32)
+			if _, err = rand.Read(key); err == nil {
+				if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+					err = ioutil.WriteFile(path, key, 0600)
+				}
+			}
+		}
+		if err == nil && len(key) != 32 {
+			err = fmt.Errorf("%s: not 32 bytes", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	case dataDir == "":
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w to %s, set %s or %s", errNoKey, k.use, k.env, k.fileEnv)
+	}
+	k.key = key
+	return key, nil
+}
+
+// loadKeys
+//
+// Load the keys sealing the tax IDs and signing the consents, so that a
+// command saving applications fails as it starts rather than once an
+// applicant answers
+func loadKeys() error {
+	for _, k := range []*serverKey{taxIDKey, signatureKey} {
+		if _, err := k.load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// within
+//
+// Whether path is dir or lies under it
+func within(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mailcatch
+//
+// `mailcatch` command: local SMTP server capturing the emails to a
+// directory instead of delivering them, to test email notifications
+func mailcatch(args []string) error {
+	flags := flag.NewFlagSet("mailcatch", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:2525", "address to listen on")
+	dir := flags.String("dir", "captured", "directory of the captured emails")
+	flags.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("capturing emails to %s, listening on %s", *dir, *addr)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go captureSession(conn, *dir)
+	}
+}
+
+// captureSession
+//
+// Minimal SMTP session saving each message as `<dir>/<id>.eml`
+func captureSession(conn net.Conn, dir string) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	reply("220 localhost mailcatch")
+	var from string
+	var to []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "HELO", "EHLO":
+			reply("250 localhost")
+		case "MAIL":
+			from, to = strings.TrimPrefix(line[4:], " FROM:"), nil
+			reply("250 OK")
+		case "RCPT":
+			to = append(to, strings.TrimPrefix(line[4:], " TO:"))
+			reply("250 OK")
+		case "DATA":
+			if len(to) == 0 {
+				reply("503 no recipient")
+				continue
+			}
+			reply("354 end with <CRLF>.<CRLF>")
+			data := &bytes.Buffer{}
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" || line == ".\n" {
+					break
+				}
+				// Undo dot-stuffing
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			id := newID()
+			path := filepath.Join(dir, id+".eml")
+			if err := ioutil.WriteFile(path, data.Bytes(), 0600); err != nil {
+				reply("451 %v", err)
+				continue
+			}
+			log.Printf("captured %s from %s to %s", path, from, strings.Join(to, ", "))
+			reply("250 OK %s", id)
+		case "RSET":
+			from, to = "", nil
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
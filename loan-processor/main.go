@@ -3,105 +3,336 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/mail"
 	"os"
-	"strconv"
 	"strings"
-	"sync"
-)
+	"time"
 
-var (
-	context  *Context
-	tasks    map[string]TaskFunc
-	workflow map[string][]*Task
+	"github.com/tuanqle/quizes/loan-processor/workflow"
 )
 
-func init() {
-	context = &Context{}
+var commands map[string]func(args []string) error
+
+// errAbandoned is returned by a task when the applicant closes the input
+var errAbandoned = errors.New("application abandoned")
+
+// maxCoBorrowers is the number of co-borrowers an application may have
+const maxCoBorrowers = 4
 
+// maxEmployments is the number of employments collected per person
+const maxEmployments = 10
+
+// maxListItems is the number of accounts or debts an application may list
+const maxListItems = 20
+
+// maxAmount is the largest amount in dollars accepted for a balance or payment
+const maxAmount = 100000000
+
+func init() {
 	// Register Task
-	tasks = map[string]TaskFunc{
-		"basicInfo":  TaskFunc{Handler: basicInfo, Context: context, Kind: "rpc"},
-		"refinance":  TaskFunc{Handler: refinance, Context: context, Kind: "rpc"},
-		"purchase":   TaskFunc{Handler: purchase, Context: context, Kind: "rpc"},
-		"coborrower": TaskFunc{Handler: coBorrower, Context: context, Kind: "rpc"},
-		"completion": TaskFunc{Handler: completion, Context: context, Kind: "rpc"},
+	for name, handler := range map[string]taskHandler{
+		"loanType":     loanSelection,
+		"refinance":    refinance,
+		"purchase":     purchase,
+		"loanTerms":    loanTerms,
+		"coborrower":   coBorrower,
+		"assets":       assets,
+		"liabilities":  liabilities,
+		"documents":    documents,
+		"signature":    submissionConsent,
+		"completion":   completion,
+		"confirmation": confirmation,
+		"review":       review,
+		"approved":     approved,
+		"declined":     declined,
+		"changes":      changes,
+		"followUp":     followUp,
+		"clearToClose": clearToClose,
+		"remind":       remind,
+		"expire":       expire,
+		"creditRetry":  creditRetry,
+	} {
+		if err := workflow.RegisterTask(name, workflow.RPC, handler.task()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := workflow.RegisterTask("creditConsent", workflow.RPC, taskHandler(creditConsent).task()); err != nil {
+		log.Fatal(err)
+	}
+	// Pull the credit report while the application goes on
+	if err := workflow.RegisterTask("creditPull", workflow.BG, taskHandler(creditPull).task()); err != nil {
+		log.Fatal(err)
+	}
+	for name, handler := range map[string]workflow.Handler{
+		"person":          person,
+		"another":         more("another", "Are you applying with another co-borrower?"),
+		"employment":      employment,
+		"moreEmployment":  moreEmployment,
+		"asset":           asset,
+		"moreAssets":      more("moreAssets", "Do you have another account?"),
+		"liability":       liability,
+		"moreLiabilities": more("moreLiabilities", "Do you have another debt?"),
+	} {
+		if err := workflow.RegisterTask(name, workflow.RPC, handler); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Predefine workflow
-	workflow = map[string][]*Task{
-		"newAccount": []*Task{
-			&Task{Name: "basicInfo", State: "enable"},
-			&Task{Name: "refinance", State: "disable"},
-			&Task{Name: "purchase", State: "disable"},
-			&Task{Name: "coborrower", State: "enable"},
-			&Task{Name: "completion", State: "enable"},
-		},
+	err := workflow.RegisterWorkflow("employmentInfo", []*workflow.Task{
+		&workflow.Task{Name: "employment", State: workflow.Enabled},
+		&workflow.Task{Name: "moreEmployment", State: workflow.Enabled},
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
-}
 
-//
-// Run
-//
-// Execute selected Task
-func (t *TaskFunc) Run() {
-	if t.Handler == nil {
-		log.Print("Task handler is undefined")
-		return
+	err = workflow.RegisterWorkflow("personInfo", []*workflow.Task{
+		&workflow.Task{Name: "person", State: workflow.Enabled},
+		&workflow.Task{Name: "employmentHistory", State: workflow.Enabled, Workflow: "employmentInfo",
+			Scope: "employment", Repeat: true, MaxRepeat: maxEmployments},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = workflow.RegisterWorkflow("coborrowerInfo", []*workflow.Task{
+		&workflow.Task{Name: "personInfo", State: workflow.Enabled, Workflow: "personInfo"},
+		&workflow.Task{Name: "another", State: workflow.Enabled},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = workflow.RegisterWorkflow("assetInfo", []*workflow.Task{
+		&workflow.Task{Name: "asset", State: workflow.Enabled},
+		&workflow.Task{Name: "moreAssets", State: workflow.Enabled},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = workflow.RegisterWorkflow("liabilityInfo", []*workflow.Task{
+		&workflow.Task{Name: "liability", State: workflow.Enabled},
+		&workflow.Task{Name: "moreLiabilities", State: workflow.Enabled},
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	t.wg = &sync.WaitGroup{}
-	t.wg.Add(1)
-	go func() {
-		defer t.wg.Done()
-		t.Error = t.Handler(t.Context)
-	}()
+	err = workflow.RegisterWorkflow("newAccount", []*workflow.Task{
+		&workflow.Task{Name: "basicInfo", State: workflow.Enabled, Workflow: "personInfo", Scope: "client"},
+		&workflow.Task{Name: "creditConsent", State: workflow.Enabled, Transitions: []*workflow.Transition{
+			&workflow.Transition{To: "creditPull", When: "authorized"},
+		}},
+		&workflow.Task{Name: "creditPull", State: workflow.Pending},
+		&workflow.Task{Name: "loanType", State: workflow.Enabled, Transitions: []*workflow.Transition{
+			&workflow.Transition{To: "refinance", When: "loan type is refinance"},
+			&workflow.Transition{To: "purchase", When: "loan type is purchase"},
+		}},
+		&workflow.Task{Name: "refinance", State: workflow.Pending},
+		&workflow.Task{Name: "purchase", State: workflow.Pending},
+		&workflow.Task{Name: "loanTerms", State: workflow.Enabled},
+		&workflow.Task{Name: "coborrower", State: workflow.Enabled, Transitions: []*workflow.Transition{
+			&workflow.Transition{To: "coborrowers", When: "applying with a co-borrower"},
+		}},
+		&workflow.Task{Name: "coborrowers", State: workflow.Pending, Workflow: "coborrowerInfo",
+			Scope: "co-borrowers", Repeat: true, MaxRepeat: maxCoBorrowers},
+		&workflow.Task{Name: "assets", State: workflow.Enabled, Transitions: []*workflow.Transition{
+			&workflow.Transition{To: "assetList", When: "holding accounts"},
+		}},
+		&workflow.Task{Name: "assetList", State: workflow.Pending, Workflow: "assetInfo",
+			Scope: "assets", Repeat: true, MaxRepeat: maxListItems},
+		&workflow.Task{Name: "liabilities", State: workflow.Enabled, Transitions: []*workflow.Transition{
+			&workflow.Transition{To: "liabilityList", When: "having debts"},
+		}},
+		&workflow.Task{Name: "liabilityList", State: workflow.Pending, Workflow: "liabilityInfo",
+			Scope: "liabilities", Repeat: true, MaxRepeat: maxListItems},
+		&workflow.Task{Name: "documents", State: workflow.Enabled},
+		&workflow.Task{Name: "signature", State: workflow.Enabled},
+		&workflow.Task{Name: "completion", State: workflow.Enabled},
+		&workflow.Task{Name: "confirmation", State: workflow.Enabled},
+		&workflow.Task{Name: "review", State: workflow.Enabled, Transitions: []*workflow.Transition{
+			&workflow.Transition{To: "approved", When: "approved by the loan officer"},
+			&workflow.Transition{To: "declined", When: "declined by the loan officer"},
+			&workflow.Transition{To: "changes", When: "changes requested by the loan officer"},
+		}},
+		&workflow.Task{Name: "approved", State: workflow.Pending, Transitions: []*workflow.Transition{
+			&workflow.Transition{To: "appraisal", When: "approved"},
+			&workflow.Transition{To: "titleSearch", When: "approved"},
+			&workflow.Transition{To: "employerVerification", When: "approved"},
+			&workflow.Transition{To: "clearToClose", When: "approved"},
+		}},
+		&workflow.Task{Name: "declined", State: workflow.Pending},
+		&workflow.Task{Name: "changes", State: workflow.Pending},
+		&workflow.Task{Name: "appraisal", State: workflow.Pending, Signal: "appraisal",
+			Timeout: appraisalTimeout, OnTimeout: "followUp"},
+		&workflow.Task{Name: "titleSearch", State: workflow.Pending, Signal: "title",
+			Timeout: titleTimeout, OnTimeout: "followUp"},
+		&workflow.Task{Name: "employerVerification", State: workflow.Pending, Signal: "employment-verification",
+			Timeout: verificationTimeout, OnTimeout: "followUp"},
+		&workflow.Task{Name: "followUp", State: workflow.Pending},
+		&workflow.Task{Name: "clearToClose", State: workflow.Pending},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Do not wait for parallel task
-	// kind: "bg"
-	if t.Kind != "bg" {
-		t.wg.Wait()
+	// Save the application as it progresses and collect metrics
+	workflow.Listen(saveContext)
+	workflow.Listen(workflow.Stats.Observe)
+
+	// Register sub-commands
+	commands = map[string]func(args []string) error{
+		"apply":     apply,
+		"serve":     serve,
+		"report":    report,
+		"graph":     graph,
+		"validate":  validate,
+		"bureau":    bureau,
+		"docs":      docs,
+		"quote":     quoteCmd,
+		"receive":   receive,
+		"outbox":    outbox,
+		"mailcatch": mailcatch,
+		"review":    reviewCmd,
+		"signal":    signal,
+		"timers":    timers,
+		"simulate":  simulate,
+		"list":      list,
 	}
 }
 
+// task
 //
-// RegisterWorkFlow
-//
-// Tracking task state to dynamically enable/disable next task
-//
-func (c *Context) RegisterWorkFlow(workName string) error {
-	tasks, ok := workflow[workName]
-	if !ok {
-		return fmt.Errorf("invalid workflow '%s'", workName)
+// Adapt a loan task handler to the workflow engine. The handler is given
+// the whole application, even within a sub-workflow.
+func (h taskHandler) task() workflow.Handler {
+	return func(r *workflow.Run) error {
+		return h(r.Root().Data.(*Context))
 	}
-	c.WorkFlow = workName
-	c.stateMap = make(map[string]string)
+}
 
-	for _, t := range tasks {
-		c.stateMap[t.Name] = t.State
+// newContext
+//
+// Start a new application executing workflow workName
+func newContext(workName string) (*Context, error) {
+	ctx := &Context{ID: newID(), Created: time.Now()}
+	run, err := workflow.NewRun(workName, ctx)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	ctx.Run = run
+	return ctx, nil
 }
 
+// Scope
 //
-// Execute
+// Part of the application handed to a sub-workflow: "client"
+func (ctx *Context) Scope(path string) (interface{}, error) {
+	switch path {
+	case "client":
+		if ctx.Client == nil {
+			ctx.Client = &Client{}
+		}
+		return ctx.Client, nil
+	}
+	return nil, fmt.Errorf("invalid scope '%s'", path)
+}
+
+// Item
 //
-// Perform workflow tasks for context
+// Element of a list collected by a repeated step: "co-borrowers",
+// "assets" or "liabilities"
+func (ctx *Context) Item(path string, index int) (interface{}, error) {
+	if index < 0 {
+		return nil, fmt.Errorf("invalid %s #%d", path, index+1)
+	}
+	switch path {
+	case "co-borrowers":
+		if index == len(ctx.CoBorrow) {
+			ctx.CoBorrow = append(ctx.CoBorrow, &Client{})
+		}
+		if index < len(ctx.CoBorrow) {
+			return ctx.CoBorrow[index], nil
+		}
+	case "assets":
+		if index == len(ctx.Assets) {
+			ctx.Assets = append(ctx.Assets, &Asset{})
+		}
+		if index < len(ctx.Assets) {
+			return ctx.Assets[index], nil
+		}
+	case "liabilities":
+		if index == len(ctx.Liabilities) {
+			ctx.Liabilities = append(ctx.Liabilities, &Liability{})
+		}
+		if index < len(ctx.Liabilities) {
+			return ctx.Liabilities[index], nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid list '%s'", path)
+	}
+	return nil, fmt.Errorf("no %s #%d", path, index+1)
+}
+
+// Truncate
 //
-func (ctx *Context) Execute() {
-	for _, task := range workflow[ctx.WorkFlow] {
-		t, ok := tasks[task.Name]
-		if !ok {
-			log.Fatalf("no task '%s' define", task.Name)
+// Keep the first n elements of a list collected by a repeated step
+func (ctx *Context) Truncate(path string, n int) error {
+	switch path {
+	case "co-borrowers":
+		if n < len(ctx.CoBorrow) {
+			ctx.CoBorrow = ctx.CoBorrow[:n]
 		}
-		if ctx.stateMap[task.Name] == "enable" {
-			t.Run()
-			if t.Error != nil {
-				log.Fatalf("%v", t.Error)
-			}
+		return nil
+	case "assets":
+		if n < len(ctx.Assets) {
+			ctx.Assets = ctx.Assets[:n]
 		}
+		return nil
+	case "liabilities":
+		if n < len(ctx.Liabilities) {
+			ctx.Liabilities = ctx.Liabilities[:n]
+		}
+		return nil
 	}
+	return fmt.Errorf("invalid list '%s'", path)
+}
+
+// saveContext
+//
+// Workflow listener saving the application whenever a task starts or ends.
+// The run is stopped when the application was saved by another writer
+// since it was loaded.
+func saveContext(r *workflow.Run, ev *workflow.Event) {
+	ctx, ok := r.Root().Data.(*Context)
+	if !ok || ev.Task == "" {
+		return
+	}
+	err := ctx.Save()
+	if errors.Is(err, ErrConflict) {
+		// Saved meanwhile by another writer: stop rather than go on with
+		// changes which cannot be saved, the application is to be reloaded
+		r.Stop(err)
+	}
+	if err != nil {
+		log.Printf("unable to save application %s: %v", ctx.ID, err)
+	}
+}
+
+// scanErr
+//
+// Error of a failed scan. End of input means the applicant left.
+func scanErr(scanner *bufio.Scanner) error {
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errAbandoned
 }
 
 //
@@ -123,7 +354,20 @@ func (l loanType) String() string {
 func (c *Client) String() string {
 	buff := &bytes.Buffer{}
 	buff.WriteString(fmt.Sprintf("  Full name: %s\n", c.Name))
-	buff.WriteString(fmt.Sprintf("        Age: %d\n", c.Age))
+	if c.Birth != nil {
+		buff.WriteString(fmt.Sprintf("       Born: %s\n", c.Birth.Format("01/02/2006")))
+	} else if c.Age > 0 {
+		buff.WriteString(fmt.Sprintf("        Age: %d\n", c.Age))
+	}
+	if c.TaxID != nil {
+		buff.WriteString(fmt.Sprintf("     Tax ID: %s\n", c.TaxID))
+	}
+	if c.Email != "" {
+		buff.WriteString(fmt.Sprintf("      Email: %s\n", c.Email))
+	}
+	for _, e := range c.Employment {
+		buff.WriteString(fmt.Sprintf("%s", e))
+	}
 	return buff.String()
 }
 
@@ -145,91 +389,89 @@ func (refi *Refinance) String() string {
 //
 func (ctx *Context) String() string {
 	buff := &bytes.Buffer{}
-	buff.WriteString(fmt.Sprintln("\nYou provided the following:\n"))
+	buff.WriteString(fmt.Sprint("\nYou provided the following:\n\n"))
 	buff.WriteString(fmt.Sprintf("YOUR INFORMATION\n"))
 	buff.WriteString(fmt.Sprintf("%s", ctx.Client))
 	buff.WriteString(fmt.Sprintf("  Loan Type: %s\n", ctx.LoanType))
+	if ctx.Property != nil {
+		buff.WriteString(fmt.Sprintf("%s", ctx.Property))
+		buff.WriteString(fmt.Sprintf("     Amount: %s\n", money(ctx.LoanAmount)))
+	}
 	switch ctx.LoanType {
 	case REFINANCE:
 		buff.WriteString(fmt.Sprintf("%s", ctx.Refinance))
 	}
-	if ctx.CoBorrow != nil {
-		buff.WriteString(fmt.Sprintf("\nCO-BORROWER INFO\n"))
-		buff.WriteString(fmt.Sprintf("%s", ctx.CoBorrow))
+	for i, client := range ctx.CoBorrow {
+		buff.WriteString(fmt.Sprintf("\nCO-BORROWER #%d INFO\n", i+1))
+		buff.WriteString(fmt.Sprintf("%s", client))
 	}
+	buff.WriteString(fmt.Sprintf("\nTOTAL MONTHLY INCOME: %s\n", money(ctx.MonthlyIncome())))
+	buff.WriteString(ctx.financesString())
+	buff.WriteString(ctx.creditString())
+	buff.WriteString(ctx.quotesString())
+	buff.WriteString(ctx.documentsString())
+	buff.WriteString(ctx.reportsString())
 	return buff.String()
 }
 
 //
-// clientInfo
+// personForm
 //
-// Collect client's name & age
+// Questions collecting the name, date of birth & tax ID of the client or
+// a co-borrower of a property in state, and the client's email to send
+// the confirmation to
 //
-func clientInfo(coborrower bool) (*Client, error) {
+func personForm(coborrower bool, state string, applied time.Time) *Form {
 	borrower := "your"
 	if coborrower == true {
 		borrower = "your co-borrower's"
 	}
-	msgs := []string{
-		fmt.Sprintf("  What is %s full name?", borrower),
-		fmt.Sprintf("  What is %s age?", borrower),
-	}
-
-	client := &Client{}
-	scanner := bufio.NewScanner(os.Stdin)
-
-	// Collect Client Name
-	fmt.Printf("%s ", msgs[0])
-	if scanner.Scan() == false {
-		return nil, scanner.Err()
-	}
-	client.Name = scanner.Text()
-
-	// Get Client Age
-	for {
-		fmt.Printf("%s ", msgs[1])
-		if scanner.Scan() == false {
-			return nil, scanner.Err()
-		}
-
-		age, err := strconv.Atoi(scanner.Text())
-		if err == nil && age > 0 {
-			client.Age = age
-			break
-		}
-
-		fmt.Println("\n    Invalid input... please try again!\n")
+	form := &Form{Name: "client", Questions: []*Question{
+		&Question{Path: "full-name", Prompt: fmt.Sprintf("What is %s full name?", borrower), Type: TEXT},
+		&Question{Path: "date-of-birth", Prompt: fmt.Sprintf("What is %s date of birth", borrower), Type: DATE,
+			Layout: "01/02/2006", Layouts: birthLayouts, Check: birthCheck(state, applied)},
+		&Question{Path: "tax-id", Prompt: fmt.Sprintf("What is %s Social Security number or ITIN [XXX-XX-XXXX]?", borrower),
+			Type: TEXT, Secret: true, Check: taxIDCheck,
+			Help: "It is needed to pull credit reports. It is stored encrypted and only its last 4 digits are shown. " +
+				"Leave it empty to keep the number given before."},
+	}}
+	if coborrower {
+		form.Name = "co-borrower"
+		return form
 	}
-
-	return client, nil
+	form.Questions = append(form.Questions, &Question{Path: "email", Prompt: "What is your email address?",
+		Type: TEXT, Help: "Your confirmation and the decision on your application are sent to this address.",
+		Check: func(data, value interface{}) error {
+			addr, err := mail.ParseAddress(value.(string))
+			if err != nil || addr.Address != value {
+				return errors.New("Invalid email address")
+			}
+			return nil
+		}})
+	return form
 }
 
-//
-// loanInfo
-//
-// Collect loanType: Purchase or Refinance
-//
-func loanInfo() (loanType, error) {
-	msg := "\nIs this loan for:\n" +
-		"  1. New purchase\n" +
-		"  2. Refinance\n" +
-		"Select Option?"
+var (
+	loanTypeForm = &Form{Name: "loan-type", Questions: []*Question{
+		&Question{Path: "loan-type", Prompt: "Is this loan for:", Type: CHOICE,
+			Options: []string{"New purchase", "Refinance"}},
+	}}
+	refinanceForm = &Form{Name: "refinance",
+		Title: "\nIf you're refinancing your loan, " +
+			"please indicate the address of the property on which " +
+			"the loan was taken out.",
+		Questions: []*Question{
+			&Question{Path: "refinance/address", Prompt: "What is the street address?", Type: TEXT},
+			&Question{Path: "refinance/city", Prompt: "What is the city?", Type: TEXT},
+			&Question{Path: "refinance/state", Prompt: "What is the state [i.e: CA]?", Type: TEXT,
+				Pattern: stateCode, Upper: true, Invalid: "Invalid state code", Check: stateCheck},
+			&Question{Path: "refinance/zipcode", Prompt: "What is the zipcode?", Type: INTEGER,
+				Max: 99999, Invalid: "Invalid zipcode"},
+		}}
+)
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Printf("%s ", msg)
-		if scanner.Scan() == false {
-			return INVALID, scanner.Err()
-		}
-		selection, _ := strconv.Atoi(scanner.Text())
-		if selection > 0 && selection < 3 {
-			return loanType(selection), nil
-		}
-		fmt.Printf("\n    Invalid selection '%d'... please try again!\n\n", selection)
-		continue
-	}
-	return INVALID, nil
-}
+// Two-letter state code
+const stateCode = `^[A-Za-z]{2}$`
 
 //
 // refinance
@@ -237,63 +479,10 @@ func loanInfo() (loanType, error) {
 // Collect information related to refinance
 //
 func refinance(ctx *Context) error {
-	refiMsg := "\nIf you're refinancing your loan, " +
-		"please indicate the address of the property on which " +
-		"the loan was taken out."
-	msgs := []string{
-		"  What is the street address?",
-		"  What is the city?",
-		"  What is the state [i.e: CA]?",
-		"  What is the zipcode?",
-	}
-
-	fmt.Println(refiMsg)
-	refi := &Refinance{}
-	scanner := bufio.NewScanner(os.Stdin)
-
-	// Street Addr
-	fmt.Printf("%s ", msgs[0])
-	if scanner.Scan() == false {
-		return scanner.Err()
-	}
-	refi.Addr = scanner.Text()
-
-	// City
-	fmt.Printf("%s ", msgs[1])
-	if scanner.Scan() == false {
-		return scanner.Err()
-	}
-	refi.City = scanner.Text()
-
-	// State
-	for {
-		fmt.Printf("%s ", msgs[2])
-		if scanner.Scan() == false {
-			return scanner.Err()
-		}
-		state := scanner.Text()
-		if len(state) == 2 {
-			refi.State = strings.ToUpper(state)
-			break
-		}
-		fmt.Println("\n    Invalid state code... please try again!\n")
-	}
-
-	// Zipcode
-	for refi.ZipCode == 0 {
-		fmt.Printf("%s ", msgs[3])
-		if scanner.Scan() == false {
-			return scanner.Err()
-		}
-		zip, err := strconv.Atoi(scanner.Text())
-		if err != nil {
-			fmt.Println("\n    Invalid state code... please try again!\n")
-			continue
-		}
-		refi.ZipCode = zip
-	}
-	ctx.Refinance = refi
-	return nil
+	ctx.Lock()
+	ctx.Refinance = &Refinance{}
+	ctx.Unlock()
+	return frontendOf(ctx.Run).Ask(refinanceForm, ctx, ctx)
 }
 
 //
@@ -311,64 +500,112 @@ func purchase(ctx *Context) error {
 // Collect coBorrower information
 //
 func coBorrower(ctx *Context) error {
-	msg := []string{
-		"  Are you applying with a co-borrower?",
-		"\nComplete the following question for your co-borrower.",
+	yes, err := confirm(ctx.Run, "coborrower", "Are you applying with a co-borrower?")
+	if err != nil || !yes {
+		return err
 	}
+	return ctx.Enable("coborrowers")
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
-
-	fmt.Printf("%s ", msg[0])
-	if scanner.Scan() == false {
-		return scanner.Err()
+//
+// person
+//
+// Collect the name, date of birth & tax ID of the client or co-borrower in
+// scope
+//
+func person(r *workflow.Run) error {
+	client, ok := r.Data.(*Client)
+	if !ok {
+		return fmt.Errorf("no client in scope '%s'", r.Scope)
 	}
-	res := strings.ToLower(scanner.Text())
-	if res == "yes" || res == "y" {
-		fmt.Println(msg[1])
-		client, err := clientInfo(true)
-		if err != nil {
-			return err
-		}
-		ctx.CoBorrow = client
 
+	coborrower := strings.HasPrefix(r.Scope, "co-borrowers")
+	msg := "Please answer the following questions:"
+	if coborrower {
+		msg = "\nComplete the following question for your co-borrower."
+	}
+	frontendOf(r).Print(msg + "\n")
+
+	// Borrowers must be old enough in the state of the property, once known
+	state, applied := "", time.Now()
+	if ctx, ok := r.Root().Data.(*Context); ok {
+		ctx.Lock()
+		state, applied = ctx.propertyState(), ctx.Created
+		ctx.Unlock()
 	}
+	form := personForm(coborrower, state, applied)
 
-	return nil
+	// The tax ID is never shown again: an empty answer keeps it
+	r.Lock()
+	*client = Client{TaxID: client.TaxID}
+	r.Unlock()
+	return frontendOf(r).Ask(form, client, r)
 }
 
 //
-// basicInfo
+// loanSelection
 //
-// Collect client information to open an account
+// Collect the loan type to enable the follow-up task
 //
-func basicInfo(ctx *Context) error {
-	msg := "Please answer the following questions:"
-
-	var err error
-	fmt.Println(msg)
-	if ctx.Client, err = clientInfo(false); err != nil {
-		return err
-	}
-
-	if ctx.LoanType, err = loanInfo(); err != nil {
+func loanSelection(ctx *Context) error {
+	if err := frontendOf(ctx.Run).Ask(loanTypeForm, ctx, ctx); err != nil {
 		return err
 	}
 
 	// Enable next Task based on LoanType
-	ctx.stateMap[ctx.LoanType.String()] = "enable"
-	return nil
+	return ctx.Enable(ctx.LoanType.String())
 }
 
 func completion(ctx *Context) error {
-	fmt.Println("Thank you for your submission.")
-	fmt.Printf("%v", ctx)
+	// The applicant is done, the loan officer takes over
+	ctx.cancelReminders()
+
+	// Rates are quoted on a best-effort basis: a loan officer can
+	// quote the application later with the `quote` command
+	quoteErr := ctx.priceApplication(time.Now())
+
+	ui := frontendOf(ctx.Run)
+	ui.Print("Thank you for your submission.\n")
+	ui.Print(ctx.String())
+	if quoteErr != nil {
+		ui.Print(fmt.Sprintf("\nRATE QUOTES\n  Not available: %v\n", quoteErr))
+	}
 	return nil
 }
 
-//
-// main
-//
-func main() {
+// apply
+//
+// `apply` command: run a loan application on the terminal
+func apply(args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory to save applications, empty to disable")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	myWorkFlow := flags.String("workflow", "newAccount", "workflow to execute")
+	resume := flags.String("resume", "", "saved application to resume")
+	configureBureau := bureauFlags(flags)
+	flags.StringVar(&rateSheetPath, "rates", rateSheetPath, "rate sheet to quote the application, JSON or CSV")
+	flags.Var(minimumAges, "min-age", "minimum borrower age by state, e.g. AL=19,MS=21")
+	configureHooks := webhookFlags(flags)
+	configureMail := mailFlags(flags)
+	flags.Parse(args)
+
+	if err := configureMail(); err != nil {
+		return err
+	}
+	closeHooks, err := configureHooks()
+	if err != nil {
+		return err
+	}
+	// Let the last notifications out before exiting
+	defer closeHooks()
+
+	if err := configureBureau(); err != nil {
+		return err
+	}
+	if err := loadKeys(); err != nil {
+		return err
+	}
+
 	// Welcome Banner
 	welcome := "=== Welcome to your loan portal ===\n" +
 		"We will collect some basic information about you now " +
@@ -376,9 +613,56 @@ func main() {
 
 	fmt.Println(welcome)
 
-	// Demonstrate workflow
-	myWorkFlow := "newAccount"
+	var ctx *Context
+	if *resume != "" {
+		ctx, err = loadContext(*resume)
+	} else {
+		ctx, err = newContext(*myWorkFlow)
+	}
+	if err != nil {
+		return err
+	}
+	if ctx.Expired != nil {
+		return fmt.Errorf("application %s expired on %s", ctx.ID, ctx.Expired.Format("2006-01-02"))
+	}
+	// Remind the applicant of the application if they leave it idle
+	if !ctx.Finished() {
+		if err := ctx.scheduleReminders(); err != nil {
+			return err
+		}
+	}
+	// The application is saved while it waits for a loan officer
+	if err := ctx.Execute(); !errors.Is(err, workflow.ErrWaiting) {
+		return err
+	}
+	return nil
+}
+
+//
+// main
+//
+func main() {
+	// Default to an application when no command is given
+	name, args := "apply", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		log.Fatalf("unknown command '%s'", name)
+	}
 
-	context.RegisterWorkFlow(myWorkFlow)
-	context.Execute()
+	// Refuse to run broken workflows
+	if name != "validate" {
+		if errs := workflow.ValidateAll(); len(errs) > 0 {
+			for _, err := range errs {
+				log.Print(err)
+			}
+			log.Fatalf("%d workflow error(s), see `validate`", len(errs))
+		}
+	}
+	if err := cmd(args); err != nil {
+		log.Fatalf("%v", err)
+	}
 }
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// rateSheetPath is the rate sheet used to quote applications
+var rateSheetPath = "ratesheet.json"
+
+// RateSheet
+//
+// Base rates of the loan products along with the price adjustments, in
+// points, applying to a scenario
+type RateSheet struct {
+	Effective   string        `json:"effective"`
+	MaxLTV      float64       `json:"max-ltv,omitempty"`
+	MinScore    int           `json:"min-score,omitempty"`
+	Products    []*Product    `json:"products"`
+	Adjustments []*Adjustment `json:"adjustments"`
+}
+
+// Product
+//
+// Loan product and the rates offered for it, each at a price in points
+type Product struct {
+	Name   string  `json:"name"`
+	Term   int     `json:"term"`
+	Prices []Price `json:"prices"`
+}
+
+// Price
+//
+// Rate offered for a number of points paid upfront
+type Price struct {
+	Rate   float64 `json:"rate"`
+	Points float64 `json:"points"`
+}
+
+// Adjustment
+//
+// Points added to every price of a scenario matching all the criteria
+// set. Bands are inclusive and a zero bound is open.
+type Adjustment struct {
+	LoanType  string  `json:"loan-type,omitempty"`
+	LTVMin    float64 `json:"ltv-min,omitempty"`
+	LTVMax    float64 `json:"ltv-max,omitempty"`
+	ScoreMin  int     `json:"score-min,omitempty"`
+	ScoreMax  int     `json:"score-max,omitempty"`
+	Occupancy string  `json:"occupancy,omitempty"`
+	State     string  `json:"state,omitempty"`
+	Points    float64 `json:"points"`
+}
+
+// Scenario
+//
+// Characteristics of a loan which are priced
+type Scenario struct {
+	LoanType  loanType
+	Amount    float64
+	Value     float64
+	Score     int
+	Occupancy occupancyType
+	State     string
+}
+
+// Enum Print
+func (o occupancyType) String() string {
+	switch o {
+	case PRIMARY:
+		return "primary"
+	case SECONDHOME:
+		return "second home"
+	case INVESTMENTPROPERTY:
+		return "investment"
+	}
+	return "invalid"
+}
+
+// parseOccupancy
+//
+// Occupancy by name: primary, second home (or second-home), investment
+func parseOccupancy(name string) (occupancyType, error) {
+	name = strings.ToLower(strings.Replace(name, "-", " ", -1))
+	for _, o := range []occupancyType{PRIMARY, SECONDHOME, INVESTMENTPROPERTY} {
+		if o.String() == name {
+			return o, nil
+		}
+	}
+	return NOOCCUPANCY, fmt.Errorf("invalid occupancy '%s'", name)
+}
+
+// parseLoanType
+//
+// Loan type by name: purchase or refinance
+func parseLoanType(name string) (loanType, error) {
+	for _, l := range []loanType{PURCHASE, REFINANCE} {
+		if l.String() == strings.ToLower(name) {
+			return l, nil
+		}
+	}
+	return INVALID, fmt.Errorf("invalid loan type '%s'", name)
+}
+
+// LTV
+//
+// Loan-to-value ratio in percent
+func (sc *Scenario) LTV() float64 {
+	if sc.Value <= 0 {
+		return 0
+	}
+	return 100 * sc.Amount / sc.Value
+}
+
+// Matches
+//
+// Whether the adjustment applies to the scenario
+func (a *Adjustment) Matches(sc *Scenario) bool {
+	ltv := sc.LTV()
+	switch {
+	case a.LoanType != "" && a.LoanType != sc.LoanType.String():
+		return false
+	case a.Occupancy != "" && a.Occupancy != sc.Occupancy.String():
+		return false
+	case a.State != "" && !strings.EqualFold(a.State, sc.State):
+		return false
+	case a.LTVMin != 0 && ltv < a.LTVMin, a.LTVMax != 0 && ltv > a.LTVMax:
+		return false
+	case a.ScoreMin != 0 && sc.Score < a.ScoreMin, a.ScoreMax != 0 && sc.Score > a.ScoreMax:
+		return false
+	}
+	return true
+}
+
+// monthlyPayment
+//
+// Principal and interest of a fully amortized loan
+func monthlyPayment(amount, rate float64, years int) float64 {
+	n := float64(12 * years)
+	r := rate / 100 / 12
+	if r == 0 {
+		return amount / n
+	}
+	return math.Round(100*amount*r/(1-math.Pow(1+r, -n))) / 100
+}
+
+// Quote
+//
+// Rates, points and monthly payments of every product for a scenario
+func (sheet *RateSheet) Quote(sc *Scenario, now time.Time) ([]*Quote, error) {
+	switch {
+	case sc.Amount <= 0 || sc.Value <= 0:
+		return nil, errors.New("loan amount and property value are required")
+	case sheet.MaxLTV != 0 && sc.LTV() > sheet.MaxLTV:
+		return nil, fmt.Errorf("loan-to-value %.1f%% exceeds the maximum of %.1f%%", sc.LTV(), sheet.MaxLTV)
+	case sheet.MinScore != 0 && sc.Score < sheet.MinScore:
+		return nil, fmt.Errorf("credit score %d is below the minimum of %d", sc.Score, sheet.MinScore)
+	}
+
+	adjust := 0.0
+	for _, a := range sheet.Adjustments {
+		if a.Matches(sc) {
+			adjust += a.Points
+		}
+	}
+
+	quotes := []*Quote{}
+	for _, p := range sheet.Products {
+		for _, price := range p.Prices {
+			quotes = append(quotes, &Quote{
+				Product:        p.Name,
+				Term:           p.Term,
+				Rate:           price.Rate,
+				Points:         math.Round(1000*(price.Points+adjust)) / 1000,
+				MonthlyPayment: monthlyPayment(sc.Amount, price.Rate, p.Term),
+				Quoted:         now,
+			})
+		}
+	}
+	return quotes, nil
+}
+
+// loadRateSheet
+//
+// Read a rate sheet in JSON format, or CSV when the file ends in `.csv`
+func loadRateSheet(path string) (*RateSheet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sheet := &RateSheet{}
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		sheet, err = readRateSheetCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(sheet)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if len(sheet.Products) == 0 {
+		return nil, fmt.Errorf("%s: no product", path)
+	}
+	for _, p := range sheet.Products {
+		if p.Term <= 0 || len(p.Prices) == 0 {
+			return nil, fmt.Errorf("%s: product '%s' needs a term and prices", path, p.Name)
+		}
+		for _, price := range p.Prices {
+			if price.Rate <= 0 {
+				return nil, fmt.Errorf("%s: product '%s' has invalid rate %v", path, p.Name, price.Rate)
+			}
+		}
+	}
+	return sheet, nil
+}
+
+// readRateSheetCSV
+//
+// Read a rate sheet from a CSV grid. The header names the columns; the
+// `kind` column tells whether a row is a `price` of a product, an
+// `adjust`ment, or the `limit`s of the sheet (effective, max-ltv, min-score).
+func readRateSheetCSV(r io.Reader) (*RateSheet, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("empty rate sheet")
+	}
+
+	header := map[string]int{}
+	for i, name := range rows[0] {
+		header[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := header["kind"]; !ok {
+		return nil, errors.New("missing 'kind' column")
+	}
+
+	sheet := &RateSheet{}
+	products := map[string]*Product{}
+	for n, row := range rows[1:] {
+		var err error
+		col := func(name string) string {
+			if i, ok := header[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		num := func(name string) float64 {
+			v := 0.0
+			if text := col(name); text != "" && err == nil {
+				if v, err = strconv.ParseFloat(text, 64); err != nil {
+					err = fmt.Errorf("invalid %s '%s'", name, text)
+				}
+			}
+			return v
+		}
+
+		switch col("kind") {
+		case "price":
+			p, ok := products[col("product")]
+			if !ok {
+				p = &Product{Name: col("product"), Term: int(num("term"))}
+				products[p.Name] = p
+				sheet.Products = append(sheet.Products, p)
+			}
+			p.Prices = append(p.Prices, Price{Rate: num("rate"), Points: num("points")})
+		case "adjust":
+			sheet.Adjustments = append(sheet.Adjustments, &Adjustment{
+				LoanType:  col("loan-type"),
+				LTVMin:    num("ltv-min"),
+				LTVMax:    num("ltv-max"),
+				ScoreMin:  int(num("score-min")),
+				ScoreMax:  int(num("score-max")),
+				Occupancy: col("occupancy"),
+				State:     col("state"),
+				Points:    num("points"),
+			})
+		case "limit":
+			if v := col("effective"); v != "" {
+				sheet.Effective = v
+			}
+			if v := num("max-ltv"); v != 0 {
+				sheet.MaxLTV = v
+			}
+			if v := int(num("min-score")); v != 0 {
+				sheet.MinScore = v
+			}
+		case "":
+			continue
+		default:
+			err = fmt.Errorf("invalid kind '%s'", col("kind"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+2, err)
+		}
+	}
+	return sheet, nil
+}
+
+// scenario
+//
+// Pricing scenario of the application, once the loan terms and the
+// credit score are known
+func (ctx *Context) scenario() (*Scenario, error) {
+	ctx.Lock()
+	defer ctx.Unlock()
+	switch {
+	case ctx.Property == nil || ctx.LoanAmount == 0:
+		return nil, errors.New("the loan terms are not known yet")
+	case ctx.Credit == nil:
+		return nil, errors.New("the credit report is not received yet")
+	}
+	return &Scenario{
+		LoanType:  ctx.LoanType,
+		Amount:    ctx.LoanAmount,
+		Value:     ctx.Property.Value,
+		Score:     ctx.Credit.Score,
+		Occupancy: ctx.Property.Occupancy,
+		State:     ctx.Property.State,
+	}, nil
+}
+
+// quote
+//
+// Price the application against the rate sheet, recording the quotes
+func (ctx *Context) quote(sheet *RateSheet, now time.Time) error {
+	sc, err := ctx.scenario()
+	if err != nil {
+		return err
+	}
+	quotes, err := sheet.Quote(sc, now)
+	if err != nil {
+		return err
+	}
+	ctx.Lock()
+	ctx.Quotes = quotes
+	ctx.Unlock()
+	return nil
+}
+
+// writeQuotes
+//
+// Table of the quotes
+func writeQuotes(w io.Writer, quotes []*Quote) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  PRODUCT\tRATE\tPOINTS\tMONTHLY PAYMENT")
+	for _, q := range quotes {
+		fmt.Fprintf(tw, "  %s\t%.3f%%\t%.3f\t%s\n", q.Product, q.Rate, q.Points, money(q.MonthlyPayment))
+	}
+	return tw.Flush()
+}
+
+// quotesString
+//
+// Quotes of the application, priced when completing it
+func (ctx *Context) quotesString() string {
+	if len(ctx.Quotes) == 0 {
+		return ""
+	}
+	buff := &bytes.Buffer{}
+	buff.WriteString("\nRATE QUOTES\n")
+	writeQuotes(buff, ctx.Quotes)
+	return buff.String()
+}
+
+// priceApplication
+//
+// Quote the application against the rate sheet at `rateSheetPath`
+func (ctx *Context) priceApplication(now time.Time) error {
+	sheet, err := loadRateSheet(rateSheetPath)
+	if err != nil {
+		return err
+	}
+	return ctx.quote(sheet, now)
+}
+
+// Property Print
+func (p *Property) String() string {
+	return fmt.Sprintf("   Property: %s, %s, %s\n", p.State, money(p.Value), p.Occupancy)
+}
+
+// loanTerms
+//
+// Collect the property, its occupancy and the amount to borrow
+func loanTerms(ctx *Context) error {
+	ctx.Lock()
+	ctx.Property, ctx.LoanAmount = &Property{}, 0
+	if ctx.LoanType == REFINANCE && ctx.Refinance != nil {
+		ctx.Property.State = ctx.Refinance.State
+	}
+	ctx.Unlock()
+	ui := frontendOf(ctx.Run)
+	ui.Print("\n")
+	return ui.Ask(loanTermsForm, ctx, ctx)
+}
+
+// refinancing
+//
+// Whether the application refinances a property whose address is known
+func refinancing(data interface{}) bool {
+	ctx := data.(*Context)
+	return ctx.LoanType == REFINANCE && ctx.Refinance != nil
+}
+
+// loanTermsForm
+//
+// Questions collecting the property and the amount to borrow. The state
+// of a refinanced property is known from its address.
+var loanTermsForm = &Form{Name: "loan-terms", Questions: []*Question{
+	&Question{Path: "property/state", Prompt: "In which state is the property [i.e: CA]?", Type: TEXT,
+		Pattern: stateCode, Upper: true, Invalid: "Invalid state code", Check: stateCheck,
+		When: func(data interface{}) bool { return !refinancing(data) }},
+	&Question{Path: "property/value", Prompt: "What is the purchase price?", Type: MONEY, Max: maxAmount,
+		When: func(data interface{}) bool { return !refinancing(data) }},
+	&Question{Path: "property/value", Prompt: "What is the estimated value of the property?", Type: MONEY,
+		Max: maxAmount, When: refinancing},
+	&Question{Path: "loan-amount", Prompt: "How much would you like to borrow?", Type: MONEY, Max: maxAmount,
+		Check: func(data, value interface{}) error {
+			if p := data.(*Context).Property; p == nil || value.(float64) > p.Value {
+				return errors.New("The amount must be positive and at most the property value")
+			}
+			return nil
+		}},
+	&Question{Path: "property/occupancy", Prompt: "How will the property be occupied?", Type: CHOICE,
+		Options: []string{"Primary residence", "Second home", "Investment property"}},
+}}
+
+// quoteCmd
+//
+// `quote` command: price a saved application, or a scenario given by
+// flags, against a rate sheet
+func quoteCmd(args []string) error {
+	flags := flag.NewFlagSet("quote", flag.ExitOnError)
+	flags.StringVar(&rateSheetPath, "rates", rateSheetPath, "rate sheet, JSON or CSV")
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	appID := flags.String("app", "", "saved application to price")
+	kind := flags.String("loan-type", "purchase", "loan type: purchase or refinance")
+	amount := flags.Float64("amount", 0, "loan amount")
+	value := flags.Float64("value", 0, "property value")
+	score := flags.Int("score", 0, "credit score")
+	occupancy := flags.String("occupancy", "primary", "occupancy: primary, second-home or investment")
+	state := flags.String("state", "", "state of the property [i.e: CA]")
+	flags.Parse(args)
+
+	sheet, err := loadRateSheet(rateSheetPath)
+	if err != nil {
+		return err
+	}
+
+	var sc *Scenario
+	if *appID != "" {
+		ctx, err := loadContext(*appID)
+		if err != nil {
+			return err
+		}
+		if sc, err = ctx.scenario(); err != nil {
+			return err
+		}
+	} else {
+		sc = &Scenario{Amount: *amount, Value: *value, Score: *score, State: *state}
+		if sc.LoanType, err = parseLoanType(*kind); err != nil {
+			return err
+		}
+		if sc.Occupancy, err = parseOccupancy(*occupancy); err != nil {
+			return err
+		}
+	}
+
+	quotes, err := sheet.Quote(sc, time.Now())
+	if err != nil {
+		return err
+	}
+	if sheet.Effective != "" {
+		fmt.Printf("Rate sheet effective %s\n", sheet.Effective)
+	}
+	fmt.Printf("%s, %s of %s (%.1f%% LTV), score %d, %s, %s\n\n",
+		sc.LoanType, money(sc.Amount), money(sc.Value), sc.LTV(), sc.Score, sc.Occupancy, sc.State)
+	return writeQuotes(os.Stdout, quotes)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// money
+//
+// Format an amount in dollars
+func money(amount float64) string {
+	s := strconv.FormatFloat(amount, 'f', 2, 64)
+	i := strings.Index(s, ".")
+	for j := i - 3; j > 0; j -= 3 {
+		s = s[:j] + "," + s[j:]
+	}
+	return "$" + s
+}
+
+// parseMoney
+//
+// Parse an amount in dollars, accepting "$" and "," separators
+func parseMoney(text string) (float64, error) {
+	text = strings.Replace(strings.TrimPrefix(strings.TrimSpace(text), "$"), ",", "", -1)
+	amount, err := strconv.ParseFloat(text, 64)
+	if err != nil || amount < 0 {
+		return 0, errors.New("Invalid amount")
+	}
+	return amount, nil
+}
+
+// frontend
+//
+// How the applicant answers the questions of the tasks: on the terminal,
+// or on web pages
+type frontend interface {
+	// Ask the questions of form, storing the answers into data
+	Ask(form *Form, data interface{}, lock sync.Locker) error
+	// Print a message to the applicant
+	Print(text string)
+}
+
+// uploader
+//
+// Front end on which the applicant attaches files, answering their path
+type uploader interface {
+	uploads() bool
+}
+
+// terminal
+//
+// Front end prompting on the standard input and output. The answers of
+// every form are read by the same scanner, so that input it read ahead,
+// e.g. piped, is not lost between forms.
+type terminal struct {
+	in *bufio.Scanner
+}
+
+// console is the terminal front end of the applications
+var console = &terminal{in: bufio.NewScanner(os.Stdin)}
+
+func (t *terminal) Ask(form *Form, data interface{}, lock sync.Locker) error {
+	return form.Ask(t.in, t, data, lock)
+}
+
+func (*terminal) Print(text string) {
+	fmt.Print(text)
+}
+
+func (*terminal) uploads() bool {
+	return true
+}
+
+func (*terminal) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// echo
+//
+// Turn the echo of the standard input on or off when it is a terminal.
+// The line ending a hidden answer is not echoed either, it is printed
+// once the echo is back on.
+func (*terminal) echo(on bool) {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	stty := exec.Command("stty", mode)
+	stty.Stdin = os.Stdin
+	if err := stty.Run(); err == nil && on {
+		fmt.Println()
+	}
+}
+
+// frontendOf
+//
+// Front end of the application executed by r, the terminal by default
+func frontendOf(r *workflow.Run) frontend {
+	if ctx, ok := r.Root().Data.(*Context); ok && ctx.ui != nil {
+		return ctx.ui
+	}
+	return console
+}
+
+// confirm
+//
+// Ask a yes/no question, form name identifying it
+func confirm(r *workflow.Run, name, msg string) (bool, error) {
+	answer := &struct {
+		Yes bool `json:"yes"`
+	}{}
+	form := &Form{Name: name, Questions: []*Question{
+		&Question{Path: "yes", Prompt: msg, Type: YESNO},
+	}}
+	err := frontendOf(r).Ask(form, answer, r)
+	return answer.Yes, err
+}
+
+// more
+//
+// Handler of a repeated sub-workflow asking whether to add another
+// element to the list, ending the loop otherwise
+func more(name, msg string) workflow.Handler {
+	return func(r *workflow.Run) error {
+		yes, err := confirm(r, name, msg)
+		if err != nil {
+			return err
+		}
+		if !yes {
+			r.Break()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fieldType
+//
+// Type of the answer to a question
+type fieldType string
+
+const (
+	TEXT    fieldType = "text"
+	INTEGER fieldType = "integer"
+	MONEY   fieldType = "money"
+	DATE    fieldType = "date"
+	YESNO   fieldType = "yes/no"
+	CHOICE  fieldType = "choice"
+)
+
+// Question
+//
+// Question of a form declared as data. The answer is parsed according to
+// Type, validated, and stored at Path: the JSON names of the fields
+// leading to it from the form's data, e.g. "refinance/state". Text may
+// have to match Pattern, numbers and amounts to lie within [Min, Max]
+// (no maximum when 0), and a required amount must be positive. Dates are
+// given in Layout, MM/YYYY by default, or in one of Layouts. A choice is
+// stored as the number of the option selected, starting at 1. Check
+// validates the answer against the data, and When tells whether the
+// question is asked at all. The answer to a Secret question is neither
+// echoed nor shown again, and an empty one keeps the answer stored.
+type Question struct {
+	Path     string                              `json:"path"`
+	Prompt   string                              `json:"prompt"`
+	Type     fieldType                           `json:"type"`
+	Help     string                              `json:"help,omitempty"`
+	Options  []string                            `json:"options,omitempty"`
+	Optional bool                                `json:"optional,omitempty"`
+	Pattern  string                              `json:"pattern,omitempty"`
+	Upper    bool                                `json:"upper,omitempty"`
+	Min      float64                             `json:"min,omitempty"`
+	Max      float64                             `json:"max,omitempty"`
+	Layout   string                              `json:"layout,omitempty"`
+	Layouts  []string                            `json:"layouts,omitempty"`
+	Secret   bool                                `json:"secret,omitempty"`
+	Invalid  string                              `json:"-"`
+	Check    func(data, value interface{}) error `json:"-"`
+	When     func(data interface{}) bool         `json:"-"`
+}
+
+// Form
+//
+// Questions collecting part of an application, in the order asked
+type Form struct {
+	Name      string      `json:"name"`
+	Title     string      `json:"title,omitempty"`
+	Questions []*Question `json:"questions"`
+}
+
+// Asked
+//
+// Whether the question applies to the data
+func (q *Question) Asked(data interface{}) bool {
+	return q.When == nil || q.When(data)
+}
+
+// keeps
+//
+// Whether the answer given as text keeps the answer stored in data, as
+// an empty answer to a secret question does
+func (q *Question) keeps(text string, data interface{}) bool {
+	if !q.Secret || strings.TrimSpace(text) != "" {
+		return false
+	}
+	v, err := field(data, q.Path, false)
+	return err == nil && v.IsValid() && !v.IsZero()
+}
+
+// invalid
+//
+// Error of an invalid answer, using the question's message when set
+func (q *Question) invalid(err error) error {
+	if q.Invalid != "" {
+		return errors.New(q.Invalid)
+	}
+	return err
+}
+
+// Parse
+//
+// Value of the answer given as text, once validated against the data.
+// An empty answer to an optional question is the zero value.
+func (q *Question) Parse(text string, data interface{}) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	if text == "" && q.Type != YESNO {
+		if !q.Optional {
+			return nil, q.invalid(errors.New("An answer is required"))
+		}
+		return nil, nil
+	}
+
+	var value interface{}
+	switch q.Type {
+	case TEXT:
+		if q.Pattern != "" && !regexp.MustCompile(q.Pattern).MatchString(text) {
+			if q.Secret {
+				return nil, q.invalid(errors.New("Invalid answer"))
+			}
+			return nil, q.invalid(fmt.Errorf("Invalid answer '%s'", text))
+		}
+		if q.Upper {
+			text = strings.ToUpper(text)
+		}
+		value = text
+	case INTEGER:
+		n, err := strconv.Atoi(text)
+		if err != nil || float64(n) < q.Min || q.Max > 0 && float64(n) > q.Max {
+			return nil, q.invalid(fmt.Errorf("Invalid number '%s'", text))
+		}
+		value = n
+	case MONEY:
+		amount, err := parseMoney(text)
+		if err != nil || amount < q.Min || q.Max > 0 && amount > q.Max || amount == 0 && !q.Optional {
+			return nil, q.invalid(errors.New("Invalid amount"))
+		}
+		value = amount
+	case DATE:
+		layout := q.Layout
+		if layout == "" {
+			layout = "01/2006"
+		}
+		t, err := time.Parse(layout, text)
+		for _, l := range q.Layouts {
+			if err == nil {
+				break
+			}
+			t, err = time.Parse(l, text)
+		}
+		if err != nil {
+			return nil, q.invalid(fmt.Errorf("Invalid date, use %s", dateHint(layout)))
+		}
+		value = t
+	case YESNO:
+		res := strings.ToLower(text)
+		value = res == "yes" || res == "y"
+	case CHOICE:
+		choice, _ := strconv.Atoi(text)
+		if choice < 1 || choice > len(q.Options) {
+			return nil, q.invalid(fmt.Errorf("Invalid selection '%s'", text))
+		}
+		value = choice
+	default:
+		return nil, fmt.Errorf("question '%s' has invalid type '%s'", q.Path, q.Type)
+	}
+
+	if q.Check != nil {
+		if err := q.Check(data, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// dateHint
+//
+// Date layout as shown to the applicant
+func dateHint(layout string) string {
+	return strings.NewReplacer("2006", "YYYY", "01", "MM", "02", "DD").Replace(layout)
+}
+
+// Value
+//
+// Current answer stored in data as text, empty when not answered or
+// secret
+func (q *Question) Value(data interface{}) string {
+	if q.Secret {
+		return ""
+	}
+	v, err := field(data, q.Path, false)
+	if err != nil || !v.IsValid() {
+		return ""
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.IsZero() && q.Type != YESNO {
+		return ""
+	}
+	switch q.Type {
+	case MONEY:
+		return strconv.FormatFloat(v.Float(), 'f', 2, 64)
+	case INTEGER, CHOICE:
+		// Choices are often enums printing their name
+		return strconv.FormatInt(v.Int(), 10)
+	case DATE:
+		layout := q.Layout
+		if layout == "" {
+			layout = "01/2006"
+		}
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.Format(layout)
+		}
+	case YESNO:
+		if v.Kind() == reflect.Bool && v.Bool() {
+			return "yes"
+		}
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// prompt
+//
+// Text of the question on the terminal
+func (q *Question) prompt() string {
+	switch q.Type {
+	case CHOICE:
+		msg := "\n" + q.Prompt + "\n"
+		for i, option := range q.Options {
+			msg += fmt.Sprintf("  %d. %s\n", i+1, option)
+		}
+		return msg + "Select Option?"
+	case DATE:
+		layout := q.Layout
+		if layout == "" {
+			layout = "01/2006"
+		}
+		return fmt.Sprintf("  %s [%s]?", q.Prompt, dateHint(layout))
+	}
+	return "  " + q.Prompt
+}
+
+// Ask
+//
+// Ask the questions of the form which apply to data, reading the answers
+// from scanner and writing the prompts to out, until each answer is
+// valid, and store the answers. "?" shows the help of a question. lock
+// guards data against concurrent tasks. Secret answers are read with the
+// echo off when out is an echoer.
+func (f *Form) Ask(scanner *bufio.Scanner, out io.Writer, data interface{}, lock sync.Locker) error {
+	if f.Title != "" {
+		fmt.Fprintln(out, f.Title)
+	}
+	for _, q := range f.Questions {
+		if !q.Asked(data) {
+			continue
+		}
+		for {
+			fmt.Fprintf(out, "%s ", q.prompt())
+			e, hidden := out.(echoer)
+			hidden = hidden && q.Secret
+			if hidden {
+				e.echo(false)
+			}
+			scanned := scanner.Scan()
+			if hidden {
+				e.echo(true)
+			}
+			if scanned == false {
+				return scanErr(scanner)
+			}
+			text := strings.TrimSpace(scanner.Text())
+			if text == "?" && q.Help != "" {
+				fmt.Fprintf(out, "\n    %s\n\n", q.Help)
+				continue
+			}
+			if q.keeps(text, data) {
+				break
+			}
+			value, err := q.Parse(text, data)
+			if err == nil {
+				lock.Lock()
+				err = setField(data, q.Path, value)
+				lock.Unlock()
+				if err != nil {
+					return err
+				}
+				break
+			}
+			fmt.Fprintf(out, "\n    %v... please try again!\n\n", err)
+		}
+	}
+	return nil
+}
+
+// Answer
+//
+// Store the answers given as text by path, e.g. from a web form, in the
+// order of the questions which apply, so that later questions are checked
+// against earlier answers. Questions without an answer are skipped.
+// Invalid answers are not stored, their errors are returned by path.
+func (f *Form) Answer(data interface{}, answers map[string]string, lock sync.Locker) map[string]error {
+	errs := map[string]error{}
+	for _, q := range f.Questions {
+		text, ok := answers[q.Path]
+		if !ok || !q.Asked(data) || q.keeps(text, data) {
+			continue
+		}
+		value, err := q.Parse(text, data)
+		if err == nil {
+			lock.Lock()
+			err = setField(data, q.Path, value)
+			lock.Unlock()
+		}
+		if err != nil {
+			errs[q.Path] = err
+		}
+	}
+	return errs
+}
+
+// field
+//
+// Field of data at path, following the JSON names of struct fields.
+// With alloc, nil pointers along the way are allocated; otherwise an
+// invalid value is returned.
+func field(data interface{}, path string, alloc bool) (reflect.Value, error) {
+	v := reflect.ValueOf(data)
+	for _, name := range strings.Split(path, "/") {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, nil
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return v, fmt.Errorf("invalid path '%s'", path)
+		}
+		next, ok := reflect.Value{}, false
+		for i := 0; i < v.NumField(); i++ {
+			tag := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if tag == name {
+				next, ok = v.Field(i), true
+				break
+			}
+		}
+		if !ok || !next.CanSet() {
+			return v, fmt.Errorf("invalid path '%s'", path)
+		}
+		v = next
+	}
+	return v, nil
+}
+
+// echoer
+//
+// Output of a front end able to stop echoing the answers, e.g. those of
+// secret questions
+type echoer interface {
+	echo(on bool)
+}
+
+// textSetter
+//
+// Field storing a text answer in its own way, e.g. sealed
+type textSetter interface {
+	setText(text string) error
+}
+
+// setField
+//
+// Store value at path in data. A nil value resets the field. Text stored
+// in a textSetter is handed to it.
+func setField(data interface{}, path string, value interface{}) error {
+	v, err := field(data, path, true)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	f := v
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	if s, ok := v.Addr().Interface().(textSetter); ok {
+		if text, ok := value.(string); ok {
+			if err := s.setText(text); err != nil {
+				f.Set(reflect.Zero(f.Type()))
+				return err
+			}
+			return nil
+		}
+	}
+	val := reflect.ValueOf(value)
+	if numeric(val.Kind()) != numeric(v.Kind()) || !val.Type().ConvertibleTo(v.Type()) {
+		return fmt.Errorf("cannot store %s in '%s'", val.Type(), path)
+	}
+	v.Set(val.Convert(v.Type()))
+	return nil
+}
+
+// numeric
+//
+// Whether values of kind k are numbers
+func numeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
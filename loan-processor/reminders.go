@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Time an applicant may leave an application idle before being reminded
+// of it, and before it expires
+const (
+	reminderIdle = 3 * 24 * time.Hour
+	expiryIdle   = 30 * 24 * time.Hour
+)
+
+// scheduleReminders
+//
+// Set the idle timers of an application the applicant is filling out
+func (ctx *Context) scheduleReminders() error {
+	if err := ctx.ScheduleIdle("reminder", "remind", reminderIdle); err != nil {
+		return err
+	}
+	return ctx.ScheduleIdle("expiry", "expire", expiryIdle)
+}
+
+// cancelReminders
+//
+// Remove the idle timers once the application is submitted
+func (ctx *Context) cancelReminders() {
+	ctx.Cancel("reminder")
+	ctx.Cancel("expiry")
+}
+
+// clientName
+//
+// Name to address the client by, even before it is collected
+func (ctx *Context) clientName() string {
+	if ctx.Client == nil || ctx.Client.Name == "" {
+		return "applicant"
+	}
+	return ctx.Client.Name
+}
+
+// remind
+//
+// Timer task reminding the client of an idle application
+func remind(ctx *Context) error {
+	next := "your application"
+	if remaining := ctx.Remaining(); len(remaining) > 0 {
+		next = fmt.Sprintf("step '%s'", remaining[0])
+	}
+	notify(ctx, fmt.Sprintf("Your loan application %s is waiting for you", ctx.ID),
+		fmt.Sprintf("Dear %s,\n\nYour loan application is %.0f%% complete. "+
+			"Pick up where you left off at %s by resuming it with its ID: %s\n\n"+
+			"Applications left idle for %d days expire.\n",
+			ctx.clientName(), ctx.PercentComplete(), next, ctx.ID, expiryIdle/(24*time.Hour)))
+	return nil
+}
+
+// expire
+//
+// Timer task expiring an application left idle
+func expire(ctx *Context) error {
+	ctx.Expire(time.Now())
+	notify(ctx, fmt.Sprintf("Your loan application %s expired", ctx.ID),
+		fmt.Sprintf("Dear %s,\n\nYour loan application was left idle for %d days and expired. "+
+			"You are welcome to start a new one.\n", ctx.clientName(), expiryIdle/(24*time.Hour)))
+	return nil
+}
+
+// fireTimers
+//
+// Run the due timers of the saved applications
+func fireTimers(now time.Time) error {
+	apps, err := loadApplications(nil)
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if at, ok := app.NextTimer(); !ok || now.Before(at) {
+			continue
+		}
+		fireTimer(app.ID, now)
+	}
+	return nil
+}
+
+// fireTimer
+//
+// Run the timers of application id which are due, loading it again with
+// its lock held
+func fireTimer(id string, now time.Time) {
+	unlock := lockApplication(id)
+	defer unlock()
+	app, err := loadContext(id)
+	if err != nil {
+		log.Printf("application %s: %v", id, err)
+		return
+	}
+	fired, err := app.Fire(now)
+	if err != nil {
+		log.Printf("application %s: %v", app.ID, err)
+	}
+	if len(fired) > 0 {
+		log.Printf("application %s: fired %v", app.ID, fired)
+	}
+	if err := app.Save(); err != nil {
+		log.Printf("unable to save application %s: %v", app.ID, err)
+	}
+}
+
+// timers
+//
+// `timers` command: list the timers of the saved applications, or run
+// those which are due
+func timers(args []string) error {
+	flags := flag.NewFlagSet("timers", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	fire := flags.Bool("fire", false, "run the timers which are due")
+	configureMail := mailFlags(flags)
+	configureBureau := bureauFlags(flags)
+	configureHooks := webhookFlags(flags)
+	flags.Parse(args)
+	if err := configureMail(); err != nil {
+		return err
+	}
+
+	if *fire {
+		if err := configureBureau(); err != nil {
+			return err
+		}
+		closeHooks, err := configureHooks()
+		if err != nil {
+			return err
+		}
+		defer closeHooks()
+		return fireTimers(time.Now())
+	}
+	apps, err := loadApplications(nil)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCLIENT\tTIMER\tTASK\tDUE")
+	for _, app := range apps {
+		if app.Expired != nil {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\texpired %s\n", app.ID, app.clientName(),
+				app.Expired.Format("2006-01-02 15:04"))
+			continue
+		}
+		names := make([]string, 0, len(app.Timers))
+		for name := range app.Timers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			t := app.Timers[name]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", app.ID, app.clientName(), name, t.Task,
+				t.At.Format("2006-01-02 15:04"))
+		}
+	}
+	return w.Flush()
+}
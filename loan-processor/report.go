@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// funnel
+//
+// Stages of the `newAccount` workflow an applicant goes through.
+// Tasks listed in the same stage are alternative branches.
+var funnel = [][]string{
+	{"basicInfo"},
+	{"loanType"},
+	{"refinance", "purchase"},
+	{"coborrower"},
+	{"completion"},
+	{"review"},
+	{"approved"},
+}
+
+// funnelReport
+//
+// Count, per stage, the applications which reached and completed it
+func funnelReport(w io.Writer, apps []*Context, workName string) error {
+	started, expired := 0, 0
+	for _, app := range apps {
+		if app.WorkFlow == workName {
+			started++
+			if app.Expired != nil {
+				expired++
+			}
+		}
+	}
+	fmt.Fprintf(w, "Workflow %s: %d application(s), %d expired\n\n", workName, started, expired)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tREACHED\tCOMPLETED\tDROPPED\tCONVERSION")
+	for _, stage := range funnel {
+		reached, completed := 0, 0
+		for _, app := range apps {
+			if app.WorkFlow != workName {
+				continue
+			}
+			r, c := false, false
+			for _, name := range stage {
+				switch app.State(name) {
+				case workflow.Completed:
+					r, c = true, true
+				case workflow.Running, workflow.Waiting, workflow.Failed:
+					r = true
+				}
+			}
+			if r {
+				reached++
+			}
+			if c {
+				completed++
+			}
+		}
+		conversion := 0.0
+		if started > 0 {
+			conversion = 100 * float64(completed) / float64(started)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f%%\n", strings.Join(stage, "/"),
+			reached, completed, reached-completed, conversion)
+	}
+	return tw.Flush()
+}
+
+// report
+//
+// `report` command: print the applicant funnel from saved applications
+func report(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	workName := flags.String("workflow", "newAccount", "workflow to report on")
+	flags.Parse(args)
+
+	apps, err := loadApplications(nil)
+	if err != nil {
+		return err
+	}
+	return funnelReport(os.Stdout, apps, *workName)
+}
+
+// list
+//
+// `list` command: print the saved applications matching a query
+func list(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	status := flags.String("status", "", "status: "+strings.Join(statuses, ", "))
+	workName := flags.String("workflow", "", "workflow executed")
+	loan := flags.String("loan-type", "", "loan type: purchase or refinance")
+	from := flags.String("from", "", "created on or after this date, YYYY-MM-DD")
+	to := flags.String("to", "", "created before this date, YYYY-MM-DD")
+	flags.Parse(args)
+
+	q, err := parseQuery(*status, *workName, *loan, *from, *to)
+	if err != nil {
+		return err
+	}
+	apps, err := loadApplications(q)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCLIENT\tWORKFLOW\tLOAN\tSTATUS\tVERSION\tCREATED")
+	for _, app := range apps {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", app.ID, app.clientName(), app.WorkFlow,
+			app.LoanType, app.Status(), app.Version, app.Created.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
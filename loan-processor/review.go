@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// Enum Print
+func (d decision) String() string {
+	switch d {
+	case APPROVE:
+		return "approve"
+	case DECLINE:
+		return "decline"
+	case CHANGES:
+		return "changes"
+	}
+	return "invalid"
+}
+
+// parseDecision
+//
+// Decision by name: approve, decline or changes
+func parseDecision(name string) (decision, error) {
+	for _, d := range []decision{APPROVE, DECLINE, CHANGES} {
+		if d.String() == strings.ToLower(name) {
+			return d, nil
+		}
+	}
+	return NODECISION, fmt.Errorf("invalid decision '%s', expected approve, decline or changes", name)
+}
+
+// Review Print
+func (r *Review) String() string {
+	s := fmt.Sprintf("  %s by %s on %s", r.Decision, r.Officer, r.Decided.Format("01/02/2006 15:04"))
+	if r.Reopen != "" {
+		s += fmt.Sprintf(", reopened at %s", r.Reopen)
+	}
+	if r.Notes != "" {
+		s += fmt.Sprintf(": %s", r.Notes)
+	}
+	return s + "\n"
+}
+
+// AwaitingReview
+//
+// Whether the application waits for a loan officer's decision
+func (ctx *Context) AwaitingReview() bool {
+	return ctx.State("review") == workflow.Waiting
+}
+
+// decision
+//
+// Officer's decision on the application as last submitted, nil until
+// one is recorded. Must be called with the lock held.
+func (ctx *Context) decision() *Review {
+	n := len(ctx.Reviews)
+	if n == 0 || ctx.Submitted == nil || !ctx.Reviews[n-1].Decided.After(*ctx.Submitted) {
+		return nil
+	}
+	return ctx.Reviews[n-1]
+}
+
+// Decide
+//
+// Record an officer's decision on an application awaiting review.
+// Applications whose signatures were tampered with cannot be approved.
+// Requesting changes reopens the application at a task preceding the
+// review, which the officer names.
+func (ctx *Context) Decide(r *Review) error {
+	if !ctx.AwaitingReview() {
+		return fmt.Errorf("application %s is not awaiting review", ctx.ID)
+	}
+	if r.Officer == "" {
+		return errors.New("the officer is required")
+	}
+	switch r.Decision {
+	case APPROVE:
+		if err := ctx.VerifySignatures(); err != nil {
+			return fmt.Errorf("application %s cannot be approved: %v", ctx.ID, err)
+		}
+		r.Reopen = ""
+	case DECLINE:
+		r.Reopen = ""
+	case CHANGES:
+		if r.Notes == "" {
+			return errors.New("notes are required to request changes")
+		}
+		if !ctx.precedes(r.Reopen, "review") {
+			return fmt.Errorf("invalid task '%s' to reopen", r.Reopen)
+		}
+	default:
+		return fmt.Errorf("invalid decision '%s'", r.Decision)
+	}
+
+	ctx.Lock()
+	defer ctx.Unlock()
+	ctx.Reviews = append(ctx.Reviews, r)
+	return nil
+}
+
+// precedes
+//
+// Whether name is a step of the application's workflow before task
+func (ctx *Context) precedes(name, task string) bool {
+	steps, _ := workflow.Lookup(ctx.WorkFlow)
+	for _, step := range steps {
+		switch step.Name {
+		case task:
+			return false
+		case name:
+			return true
+		}
+	}
+	return false
+}
+
+// resumeReview
+//
+// Resume an application down the branch of the decision just recorded.
+// An application sent back for changes is reopened at the task named by
+// the officer, for the client to resume it.
+func (ctx *Context) resumeReview() error {
+	if err := ctx.Execute(); err != nil && !errors.Is(err, workflow.ErrWaiting) {
+		return err
+	}
+
+	ctx.Lock()
+	r := ctx.Reviews[len(ctx.Reviews)-1]
+	ctx.Unlock()
+	if r.Decision != CHANGES {
+		return nil
+	}
+	if err := ctx.Back(r.Reopen); err != nil {
+		return err
+	}
+	// The application awaits a new decision once submitted again
+	ctx.Lock()
+	ctx.Submitted = nil
+	ctx.Unlock()
+	return ctx.Save()
+}
+
+// review
+//
+// Wait for a loan officer's decision, then enable the branch following
+// it: `approved`, `declined` or `changes`
+func review(ctx *Context) error {
+	ctx.Lock()
+	r := ctx.decision()
+	if r == nil {
+		now := time.Now()
+		ctx.Submitted = &now
+	}
+	ctx.Unlock()
+
+	if r == nil {
+		frontendOf(ctx.Run).Print(fmt.Sprintf("\nYour application %s was submitted to a loan officer for review.\n", ctx.ID))
+		return workflow.ErrWaiting
+	}
+	switch r.Decision {
+	case APPROVE:
+		return ctx.Enable("approved")
+	case DECLINE:
+		return ctx.Enable("declined")
+	}
+	return ctx.Enable("changes")
+}
+
+// notify
+//
+// Print a notice to the client, and email it when email is configured
+func notify(ctx *Context, subject, body string) {
+	frontendOf(ctx.Run).Print(fmt.Sprintf("\n%s\n\n%s", subject, body))
+	if emailer == nil || ctx.Client == nil || ctx.Client.Email == "" {
+		return
+	}
+	if _, err := emailer.queue(ctx.Client.Email, subject, body, time.Now()); err != nil {
+		log.Printf("notice to %s: %v, it will be sent later", ctx.Client.Email, err)
+	}
+}
+
+// notes
+//
+// Officer's notes of the last review
+func (ctx *Context) notes() string {
+	ctx.Lock()
+	defer ctx.Unlock()
+	if n := len(ctx.Reviews); n > 0 && ctx.Reviews[n-1].Notes != "" {
+		return fmt.Sprintf("\nNotes from your loan officer:\n  %s\n", ctx.Reviews[n-1].Notes)
+	}
+	return ""
+}
+
+// approved
+//
+// Notify the client of the approval, and await the third parties'
+// reports before closing
+func approved(ctx *Context) error {
+	notify(ctx, fmt.Sprintf("Your loan application %s was approved", ctx.ID),
+		fmt.Sprintf("Dear %s,\n\nCongratulations, your %s loan application was approved.\n%s",
+			ctx.Client.Name, ctx.LoanType, ctx.notes()))
+	for _, name := range []string{"appraisal", "titleSearch", "employerVerification", "clearToClose"} {
+		if err := ctx.Enable(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declined
+//
+// Notify the client that the application was declined
+func declined(ctx *Context) error {
+	notify(ctx, fmt.Sprintf("Your loan application %s was declined", ctx.ID),
+		fmt.Sprintf("Dear %s,\n\nWe are sorry, we cannot offer you a loan at this time.\n%s",
+			ctx.Client.Name, ctx.notes()))
+	return nil
+}
+
+// changes
+//
+// Ask the client to change the application
+func changes(ctx *Context) error {
+	notify(ctx, fmt.Sprintf("Your loan application %s needs changes", ctx.ID),
+		fmt.Sprintf("Dear %s,\n\nYour loan officer needs you to update your application.\n%s"+
+			"\nPlease resume it with its ID: %s\n", ctx.Client.Name, ctx.notes(), ctx.ID))
+	return ctx.scheduleReminders()
+}
+
+// writePending
+//
+// Table of the applications awaiting review
+func writePending(w *tabwriter.Writer, apps []*Context) error {
+	fmt.Fprintln(w, "ID\tCLIENT\tLOAN\tAMOUNT\tSCORE\tDOCUMENTS\tSUBMITTED")
+	for _, app := range apps {
+		if !app.AwaitingReview() {
+			continue
+		}
+		name, score, missing := "", "-", 0
+		if app.Client != nil {
+			name = app.Client.Name
+		}
+		if app.Credit != nil {
+			score = fmt.Sprint(app.Credit.Score)
+		}
+		for _, d := range app.Documents {
+			if d.Outstanding() {
+				missing++
+			}
+		}
+		submitted := ""
+		if app.Submitted != nil {
+			submitted = app.Submitted.Format("01/02/2006 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d outstanding\t%s\n",
+			app.ID, name, app.LoanType, money(app.LoanAmount), score, missing, submitted)
+	}
+	return w.Flush()
+}
+
+// reviewCmd
+//
+// `review` command: list the applications awaiting review, show one, or
+// record an officer's decision and resume the application
+func reviewCmd(args []string) error {
+	flags := flag.NewFlagSet("review", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	appID := flags.String("app", "", "application to show or decide on")
+	name := flags.String("decision", "", "decision: approve, decline or changes")
+	officer := flags.String("officer", os.Getenv("USER"), "loan officer deciding")
+	notes := flags.String("notes", "", "notes to the client, required to request changes")
+	reopen := flags.String("reopen", "", "task the client resumes at, to request changes")
+	configureMail := mailFlags(flags)
+	configureBureau := bureauFlags(flags)
+	configureHooks := webhookFlags(flags)
+	flags.Parse(args)
+	if err := configureMail(); err != nil {
+		return err
+	}
+
+	if *appID == "" {
+		apps, err := loadApplications(&Query{Status: "review"})
+		if err != nil {
+			return err
+		}
+		return writePending(tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0), apps)
+	}
+
+	ctx, err := loadContext(*appID)
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		fmt.Printf("Application %s: %s\n%v", ctx.ID, ctx.Remaining(), ctx)
+		fmt.Print(ctx.signaturesString())
+		if len(ctx.Reviews) > 0 {
+			fmt.Println("\nREVIEWS")
+			for _, r := range ctx.Reviews {
+				fmt.Print(r)
+			}
+		}
+		return nil
+	}
+
+	d, err := parseDecision(*name)
+	if err != nil {
+		return err
+	}
+	r := &Review{Decision: d, Officer: *officer, Notes: *notes, Reopen: *reopen, Decided: time.Now()}
+	if err := ctx.Decide(r); err != nil {
+		return err
+	}
+	if err := configureBureau(); err != nil {
+		return err
+	}
+	closeHooks, err := configureHooks()
+	if err != nil {
+		return err
+	}
+	defer closeHooks()
+	return ctx.resumeReview()
+}
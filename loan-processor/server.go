@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// serve
+//
+// `serve` command: run the program as a service
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	myWorkFlow := flags.String("workflow", "newAccount", "workflow of the applications started on the web")
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	flags.Var(minimumAges, "min-age", "minimum borrower age by state, e.g. AL=19,MS=21")
+	configureMail := mailFlags(flags)
+	configureBureau := bureauFlags(flags)
+	configureHooks := webhookFlags(flags)
+	flags.Parse(args)
+	if err := configureMail(); err != nil {
+		return err
+	}
+	if err := configureBureau(); err != nil {
+		return err
+	}
+	closeHooks, err := configureHooks()
+	if err != nil {
+		return err
+	}
+	defer closeHooks()
+	if err := loadKeys(); err != nil {
+		return err
+	}
+
+	// Retry the emails of the outbox
+	if emailer != nil {
+		go func() {
+			for now := range time.Tick(time.Minute) {
+				if _, err := emailer.flush(now); err != nil {
+					log.Printf("outbox: %v", err)
+				}
+			}
+		}()
+	}
+
+	// Resume the applications whose signals timed out, and remind
+	// applicants of idle applications
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			if err := expireSignals(now); err != nil {
+				log.Printf("signals: %v", err)
+			}
+			if err := fireTimers(now); err != nil {
+				log.Printf("timers: %v", err)
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", workflow.Stats)
+
+	// Loan officer API
+	officers := &officerAPI{token: os.Getenv("OFFICER_TOKEN"), taxIDToken: os.Getenv("TAX_ID_TOKEN")}
+	if officers.token == "" {
+		log.Print("OFFICER_TOKEN is not set, the loan officer API is disabled")
+	}
+	mux.HandleFunc("/applications", officers.auth(officers.list))
+	mux.HandleFunc("/applications/", officers.auth(officers.application))
+
+	// Applicants' web forms
+	web := &webApply{workflow: *myWorkFlow}
+	mux.HandleFunc("/apply", web.start)
+	mux.HandleFunc("/apply/", web.application)
+
+	log.Printf("listening on %s", *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+// officerAPI
+//
+// Endpoints for loan officers to review applications. Requests carry
+// the OFFICER_TOKEN as a bearer token. Applications are exported with
+// their tax IDs masked, unless revealed with the TAX_ID_TOKEN.
+type officerAPI struct {
+	token      string
+	taxIDToken string
+}
+
+// auth
+//
+// Reject requests without the officers' token
+func (api *officerAPI) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if api.token == "" {
+			http.Error(w, "loan officer API is disabled", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// application
+//
+// Route /applications/{id}, /applications/{id}/decision and
+// /applications/{id}/signals/{name}
+func (api *officerAPI) application(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/applications/"), "/")
+	id := parts[0]
+	switch {
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		api.get(w, req, id)
+	case len(parts) == 2 && parts[1] == "decision" && req.Method == http.MethodPost:
+		api.decide(w, req, id)
+	case len(parts) == 3 && parts[1] == "signals" && req.Method == http.MethodPost:
+		api.signal(w, req, id, parts[2])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// writeJSON
+//
+// Answer v in JSON format
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// list
+//
+// GET /applications: applications, selected by `?status=`, `workflow=`,
+// `loan-type=`, `from=` and `to=`. `?pending=review` stands for
+// `?status=review`.
+func (api *officerAPI) list(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	v := req.URL.Query()
+	status := v.Get("status")
+	if v.Get("pending") == "review" {
+		status = "review"
+	}
+	q, err := parseQuery(status, v.Get("workflow"), v.Get("loan-type"), v.Get("from"), v.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apps, err := loadApplications(q)
+	if errors.Is(err, os.ErrNotExist) {
+		apps = []*Context{}
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i, ctx := range apps {
+		apps[i], _ = ctx.exported(false)
+	}
+	writeJSON(w, http.StatusOK, apps)
+}
+
+// get
+//
+// GET /applications/{id}: the application. `?reveal=tax-id` reveals the
+// numbers of the tax IDs to requests carrying the TAX_ID_TOKEN in
+// X-Tax-ID-Token.
+func (api *officerAPI) get(w http.ResponseWriter, req *http.Request, id string) {
+	reveal := req.URL.Query().Get("reveal") == "tax-id"
+	if reveal && (api.taxIDToken == "" ||
+		subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Tax-ID-Token")), []byte(api.taxIDToken)) != 1) {
+		http.Error(w, "not authorized to reveal tax IDs", http.StatusForbidden)
+		return
+	}
+	ctx, err := loadContext(id)
+	if err != nil {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	exported, err := ctx.exported(reveal)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reveal {
+		log.Printf("application %s: tax IDs revealed to %s", ctx.ID, req.RemoteAddr)
+	}
+	writeJSON(w, http.StatusOK, exported)
+}
+
+// decide
+//
+// POST /applications/{id}/decision: record the decision given as JSON
+// (decision, officer, notes, reopen) and resume the application
+func (api *officerAPI) decide(w http.ResponseWriter, req *http.Request, id string) {
+	body := struct {
+		Decision string `json:"decision"`
+		Officer  string `json:"officer"`
+		Notes    string `json:"notes"`
+		Reopen   string `json:"reopen"`
+	}{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	d, err := parseDecision(body.Decision)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unlock := lockApplication(id)
+	defer unlock()
+	ctx, err := loadContext(id)
+	if err != nil {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	r := &Review{Decision: d, Officer: body.Officer, Notes: body.Notes, Reopen: body.Reopen, Decided: time.Now()}
+	if err := ctx.Decide(r); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err := ctx.resumeReview(); errors.Is(err, ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ctx.Export())
+}
+
+// signal
+//
+// POST /applications/{id}/signals/{name}: deliver a third party's signal
+// with the JSON payload of the request, resuming the application
+func (api *officerAPI) signal(w http.ResponseWriter, req *http.Request, id, name string) {
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	unlock := lockApplication(id)
+	defer unlock()
+	ctx, err := deliverSignal(id, name, payload)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, ctx.Export())
+}
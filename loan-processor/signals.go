@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// Time third parties have to report before the loan officer follows up
+const (
+	appraisalTimeout    = 14 * 24 * time.Hour
+	titleTimeout        = 14 * 24 * time.Hour
+	verificationTimeout = 3 * 24 * time.Hour
+)
+
+// Receive
+//
+// Merge the payload of a third party's signal into the application:
+// "appraisal", "title" or "employment-verification"
+func (ctx *Context) Receive(signal string, payload []byte) error {
+	decode := func(v interface{}) error {
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("signal '%s': %v", signal, err)
+		}
+		return nil
+	}
+
+	switch signal {
+	case "appraisal":
+		a := &Appraisal{}
+		if err := decode(a); err != nil {
+			return err
+		}
+		if a.Value <= 0 || a.Value > maxAmount {
+			return fmt.Errorf("signal '%s': invalid value %v", signal, a.Value)
+		}
+		ctx.Lock()
+		ctx.Appraisal = a
+		ctx.Unlock()
+	case "title":
+		t := &TitleSearch{}
+		if err := decode(t); err != nil {
+			return err
+		}
+		ctx.Lock()
+		ctx.Title = t
+		ctx.Unlock()
+	case "employment-verification":
+		v := &Verification{}
+		if err := decode(v); err != nil {
+			return err
+		}
+		ctx.Lock()
+		ctx.Verification = v
+		ctx.Unlock()
+	default:
+		return fmt.Errorf("invalid signal '%s'", signal)
+	}
+	return nil
+}
+
+// outstandingReports
+//
+// Third-party reports not received yet
+func (ctx *Context) outstandingReports() []string {
+	ctx.Lock()
+	defer ctx.Unlock()
+	missing := []string{}
+	if ctx.Appraisal == nil {
+		missing = append(missing, "appraisal")
+	}
+	if ctx.Title == nil {
+		missing = append(missing, "title search")
+	}
+	if ctx.Verification == nil {
+		missing = append(missing, "employment verification")
+	}
+	return missing
+}
+
+// reportsString
+//
+// Summary of the third-party reports received
+func (ctx *Context) reportsString() string {
+	if ctx.Appraisal == nil && ctx.Title == nil && ctx.Verification == nil {
+		return ""
+	}
+	buff := &bytes.Buffer{}
+	buff.WriteString("\nTHIRD-PARTY REPORTS\n")
+	if a := ctx.Appraisal; a != nil {
+		buff.WriteString(fmt.Sprintf("  Appraisal: %s", money(a.Value)))
+		if a.Value > 0 {
+			buff.WriteString(fmt.Sprintf(" (%.1f%% LTV)", 100*ctx.LoanAmount/a.Value))
+		}
+		buff.WriteString("\n")
+	}
+	if t := ctx.Title; t != nil {
+		status := "clear"
+		if !t.Clear {
+			status = "liens: " + strings.Join(t.Liens, ", ")
+		}
+		buff.WriteString(fmt.Sprintf("  Title: %s\n", status))
+	}
+	if v := ctx.Verification; v != nil {
+		status := "verified"
+		if !v.Verified {
+			status = "not verified"
+		}
+		buff.WriteString(fmt.Sprintf("  Employment: %s %s\n", v.Employer, status))
+	}
+	return buff.String()
+}
+
+// followUp
+//
+// Alert the loan officer that third-party reports are overdue. It runs
+// again each time a report is still overdue after its timeout.
+func followUp(ctx *Context) error {
+	missing := ctx.outstandingReports()
+	if len(missing) == 0 {
+		return nil
+	}
+	log.Printf("application %s: overdue %s, please follow up", ctx.ID, strings.Join(missing, ", "))
+	return nil
+}
+
+// clearToClose
+//
+// Tell whether the approved application can close: the property is
+// appraised at least at the loan amount, the title is clear and the
+// employment is verified
+func clearToClose(ctx *Context) error {
+	issues := ctx.outstandingReports()
+	ctx.Lock()
+	if a := ctx.Appraisal; a != nil && a.Value < ctx.LoanAmount {
+		issues = append(issues, fmt.Sprintf("appraised at %s, below the loan amount", money(a.Value)))
+	}
+	if t := ctx.Title; t != nil && !t.Clear {
+		issues = append(issues, "title is not clear")
+	}
+	if v := ctx.Verification; v != nil && !v.Verified {
+		issues = append(issues, "employment is not verified")
+	}
+	ctx.Unlock()
+
+	if len(issues) > 0 {
+		log.Printf("application %s is not clear to close: %s", ctx.ID, strings.Join(issues, "; "))
+		return nil
+	}
+	notify(ctx, fmt.Sprintf("Your loan %s is clear to close", ctx.ID),
+		fmt.Sprintf("Dear %s,\n\nAll the conditions of your loan are met. "+
+			"We will contact you to schedule the closing.\n", ctx.Client.Name))
+	return nil
+}
+
+// deliverSignal
+//
+// Record a signal on the saved application id and resume it when the
+// signal is awaited. A signal delivered early is kept until awaited.
+func deliverSignal(id, name string, payload []byte) (*Context, error) {
+	ctx, err := loadContext(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Signal(name, payload); err != nil {
+		return nil, err
+	}
+	if !ctx.Awaiting(name) {
+		return ctx, ctx.Save()
+	}
+	if err := ctx.Execute(); err != nil && !errors.Is(err, workflow.ErrWaiting) {
+		return ctx, err
+	}
+	return ctx, nil
+}
+
+// expireSignals
+//
+// Resume the approved applications whose signals timed out
+func expireSignals(now time.Time) error {
+	apps, err := loadApplications(&Query{Status: "approved"})
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if deadline, ok := app.Deadline(); !ok || now.Before(deadline) {
+			continue
+		}
+		if err := expireSignal(app.ID, now); err != nil && !errors.Is(err, workflow.ErrWaiting) {
+			log.Printf("application %s: %v", app.ID, err)
+		}
+	}
+	return nil
+}
+
+// expireSignal
+//
+// Resume application id, loaded again with its lock held, if its signals
+// still timed out
+func expireSignal(id string, now time.Time) error {
+	unlock := lockApplication(id)
+	defer unlock()
+	app, err := loadContext(id)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := app.Deadline(); !ok || now.Before(deadline) {
+		return nil
+	}
+	return app.Execute()
+}
+
+// signal
+//
+// `signal` command: deliver a third party's signal to an application,
+// or resume the applications whose signals timed out
+func signal(args []string) error {
+	flags := flag.NewFlagSet("signal", flag.ExitOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of saved applications")
+	flags.StringVar(&storeKind, "store", storeKind, "storage of the applications: json or db")
+	appID := flags.String("app", "", "application to signal")
+	name := flags.String("name", "", "signal: appraisal, title or employment-verification")
+	payload := flags.String("payload", "", "payload in JSON format, or @<file>")
+	expire := flags.Bool("expire", false, "resume the applications whose signals timed out")
+	configureMail := mailFlags(flags)
+	configureBureau := bureauFlags(flags)
+	configureHooks := webhookFlags(flags)
+	flags.Parse(args)
+	if err := configureMail(); err != nil {
+		return err
+	}
+	if err := configureBureau(); err != nil {
+		return err
+	}
+	closeHooks, err := configureHooks()
+	if err != nil {
+		return err
+	}
+	defer closeHooks()
+
+	if *expire {
+		return expireSignals(time.Now())
+	}
+	if *appID == "" || *name == "" {
+		return errors.New("an application (-app) and a signal (-name) are required")
+	}
+	body := []byte(*payload)
+	if strings.HasPrefix(*payload, "@") {
+		var err error
+		if body, err = ioutil.ReadFile(strings.TrimPrefix(*payload, "@")); err != nil {
+			return err
+		}
+	}
+	ctx, err := deliverSignal(*appID, *name, body)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "signal '%s' delivered to %s, remaining: %s\n", *name, ctx.ID, ctx.Remaining())
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests an applicant makes before the server is deemed stuck
+const maxRequests = 300
+
+// Parts of the web forms' pages, see webTemplate
+var (
+	pageFormRE    = regexp.MustCompile(`name="form" value="([^"]*)"`)
+	pageVersionRE = regexp.MustCompile(`name="version" value="(\d+)"`)
+	pageAskedRE   = regexp.MustCompile(`name="asked" value="([^"]*)"`)
+	pageRadioRE   = regexp.MustCompile(`<input type="radio" name="([^"]*)" value="([^"]*)"`)
+)
+
+// pageQuestion
+//
+// Question of a web form page, as much of it as the page tells
+func pageQuestion(page, path string) *Question {
+	q := &Question{Path: path, Type: TEXT}
+	for _, m := range pageRadioRE.FindAllStringSubmatch(page, -1) {
+		if html.UnescapeString(m[1]) != path {
+			continue
+		}
+		if m[2] == "yes" || m[2] == "no" {
+			q.Type = YESNO
+		} else {
+			q.Type = CHOICE
+			q.Options = append(q.Options, m[2])
+		}
+	}
+	return q
+}
+
+// loadStats
+//
+// Requests made to the server and how long they took
+type loadStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	failures  map[string]int
+	submitted int
+	failed    int
+}
+
+func (s *loadStats) record(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, d)
+	if err != nil {
+		s.failures[err.Error()]++
+	}
+}
+
+// fill
+//
+// Fill out an application on the web forms of server as applicant a
+func (s *loadStats) fill(client *http.Client, server string, a *applicant) error {
+	request := func(method, path string, form url.Values) (*http.Response, string, error) {
+		start := time.Now()
+		var resp *http.Response
+		var err error
+		if method == http.MethodPost {
+			resp, err = client.PostForm(server+path, form)
+		} else {
+			resp, err = client.Get(server + path)
+		}
+		if err != nil {
+			s.record(time.Since(start), err)
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err == nil && resp.StatusCode >= 400 {
+			err = fmt.Errorf("%s %s: %s", method, strings.Split(path, "?")[0], resp.Status)
+		}
+		s.record(time.Since(start), err)
+		return resp, string(body), err
+	}
+
+	resp, _, err := request(http.MethodPost, "/apply", url.Values{})
+	if err != nil {
+		return err
+	}
+	path := resp.Header.Get("Location")
+	if !strings.HasPrefix(path, "/apply/") {
+		return errors.New("POST /apply: no application page")
+	}
+	_, page, err := request(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+
+	// Answers drawn for the form of the page, given again when the page
+	// shows the same form with more questions applying and no errors
+	drawn, last := map[string]string{}, ""
+	for i := 0; i < maxRequests; i++ {
+		form := pageFormRE.FindStringSubmatch(page)
+		version := pageVersionRE.FindStringSubmatch(page)
+		if form == nil || version == nil {
+			// No form left: the application is submitted
+			return nil
+		}
+		values := url.Values{"form": {html.UnescapeString(form[1])}, "version": {version[1]}}
+		asked := []string{}
+		more := false
+		for _, m := range pageAskedRE.FindAllStringSubmatch(page, -1) {
+			p := html.UnescapeString(m[1])
+			if _, ok := drawn[p]; !ok {
+				more = true
+			}
+			asked = append(asked, p)
+		}
+		if name := values.Get("form"); name != last || !more || strings.Contains(page, "<strong>") {
+			drawn, last = map[string]string{}, name
+		}
+		for _, p := range asked {
+			value, ok := drawn[p]
+			if !ok {
+				value = a.next(last, pageQuestion(page, p))
+				drawn[p] = value
+			}
+			values.Add("asked", p)
+			values.Set(p, value)
+		}
+		if _, page, err = request(http.MethodPost, path, values); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("application %s not submitted after %d requests", path, maxRequests)
+}
+
+// loadTest
+//
+// Fill out n applications on the web forms of server, concurrency at a
+// time, and report the latency of the requests
+func loadTest(server string, n, concurrency int, seed int64, invalid float64) error {
+	server = strings.TrimSuffix(server, "/")
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		// Application pages are followed by hand
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	s := &loadStats{failures: map[string]int{}}
+
+	start := time.Now()
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := s.fill(client, server, newApplicant(seed+int64(i), invalid))
+				s.mu.Lock()
+				if err != nil {
+					s.failed++
+				} else {
+					s.submitted++
+				}
+				s.mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(s.latencies) == 0 {
+			return 0
+		}
+		return s.latencies[int(p*float64(len(s.latencies)-1))].Round(time.Microsecond)
+	}
+	fmt.Printf("%d applicant(s) on %s, %d at a time, in %v: %d submitted, %d failed\n",
+		n, server, concurrency, elapsed.Round(time.Millisecond), s.submitted, s.failed)
+	fmt.Printf("%d request(s), %.1f/s, latency p50 %v, p95 %v, p99 %v, max %v\n",
+		len(s.latencies), float64(len(s.latencies))/elapsed.Seconds(),
+		percentile(0.5), percentile(0.95), percentile(0.99), percentile(1))
+	if len(s.failures) > 0 {
+		fmt.Println("\nERRORS")
+		keys := make([]string, 0, len(s.failures))
+		for k := range s.failures {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("  %s (%d)\n", k, s.failures[k])
+		}
+	}
+	if s.failed > 0 {
+		return fmt.Errorf("%d of %d application(s) failed", s.failed, n)
+	}
+	return nil
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// Invalid answers given in a row before the applicant gives up
+const maxInvalid = 20
+
+// Pools synthetic applicants are drawn from
+var (
+	firstNames = []string{"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda",
+		"David", "Elizabeth", "Carlos", "Maria", "Wei", "Mei", "Ahmed", "Fatima", "Olga", "Raj", "Priya", "Kenji"}
+	lastNames = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis",
+		"Rodriguez", "Martinez", "Nguyen", "Chen", "Kim", "Patel", "Khan", "Cohen", "Murphy", "Novak"}
+	companies = []string{"Acme Corp", "Globex", "Initech", "Umbrella Health", "Stark Industries",
+		"Wayne Enterprises", "Hooli", "Vandelay Industries", "Soylent Foods", "Cyberdyne Systems"}
+	positions = []string{"Engineer", "Analyst", "Manager", "Nurse", "Teacher", "Accountant",
+		"Technician", "Designer", "Sales Associate", "Director"}
+	streets = []string{"Oak Street", "Maple Avenue", "Cedar Lane", "Elm Drive", "Pine Road",
+		"Washington Boulevard", "Lake Shore Drive", "Hillside Court"}
+	cities = []string{"Austin", "Denver", "Portland", "Columbus", "Raleigh", "Phoenix", "Madison",
+		"Sacramento", "Boise", "Richmond"}
+	stateCodes = []string{"CA", "TX", "NY", "FL", "WA", "CO", "OR", "NC", "OH", "AZ", "GA", "MA"}
+	banks      = []string{"First National Bank", "Chase", "Wells Fargo", "Credit Union of America",
+		"Fidelity", "Vanguard", "Ally Bank"}
+	lenders = []string{"Visa", "Mastercard", "Ford Credit", "Toyota Financial", "Sallie Mae",
+		"Navient", "Quicken Loans", "Discover"}
+)
+
+// Odds of answering yes, by form
+var yesOdds = map[string]float64{
+	"creditConsent":   0.9,
+	"signature":       0.95,
+	"coborrower":      0.3,
+	"another":         0.15,
+	"moreEmployment":  0.4,
+	"assets":          0.75,
+	"moreAssets":      0.3,
+	"debts":           0.6,
+	"moreLiabilities": 0.35,
+}
+
+// applicant
+//
+// Synthetic applicant answering the questions of the forms at random,
+// but realistically, giving an invalid answer first now and then. It
+// remembers the answers later ones depend on.
+type applicant struct {
+	rnd      *rand.Rand
+	invalid  float64 // odds of an invalid answer
+	name     string  // of the person being described
+	signer   string  // name of the client, signing the disclosures
+	value    float64 // of the property
+	start    time.Time
+	overdue  map[string]bool // reports let past their deadline
+	answers  int
+	invalids int
+}
+
+// newApplicant
+//
+// Applicant drawn from seed, giving invalid answers at the odds of invalid
+func newApplicant(seed int64, invalid float64) *applicant {
+	return &applicant{rnd: rand.New(rand.NewSource(seed)), invalid: invalid, overdue: map[string]bool{}}
+}
+
+func (a *applicant) pick(options []string) string {
+	return options[a.rnd.Intn(len(options))]
+}
+
+// amount
+//
+// Random amount in [min, max] rounded to step
+func (a *applicant) amount(min, max, step float64) string {
+	return strconv.FormatFloat(min+step*float64(a.rnd.Intn(int((max-min)/step)+1)), 'f', 0, 64)
+}
+
+// month
+//
+// Random month within the last years, not the current one
+func (a *applicant) month(years int) time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month()-time.Month(1+a.rnd.Intn(12*years)), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// answer
+//
+// Valid answer to question q of form, as text
+func (a *applicant) answer(form string, q *Question) string {
+	switch q.Path {
+	case "full-name":
+		a.name = a.pick(firstNames) + " " + a.pick(lastNames)
+		if form == "client" {
+			a.signer = a.name
+		}
+		return a.name
+	case "signature":
+		return a.signer
+	case "date-of-birth":
+		return time.Now().AddDate(-22-a.rnd.Intn(50), 0, -a.rnd.Intn(365)).Format("01/02/2006")
+	case "tax-id":
+		area := 1 + a.rnd.Intn(898)
+		if area == 666 {
+			area = 667
+		}
+		return fmt.Sprintf("%03d-%02d-%04d", area, 1+a.rnd.Intn(99), 1+a.rnd.Intn(9999))
+	case "email":
+		return strings.ToLower(strings.Replace(a.name, " ", ".", -1)) + "@example.com"
+	case "employer":
+		return a.pick(companies)
+	case "position":
+		return a.pick(positions)
+	case "start-date":
+		a.start = a.month(8)
+		return a.start.Format("01/2006")
+	case "end-date":
+		// Between the start and last month
+		months := int(time.Since(a.start).Hours()/24/30) - 1
+		if months < 1 {
+			return ""
+		}
+		return a.start.AddDate(0, 1+a.rnd.Intn(months), 0).Format("01/2006")
+	case "monthly-income":
+		return a.amount(2000, 15000, 50)
+	case "refinance/address":
+		return fmt.Sprintf("%d %s", 1+a.rnd.Intn(9999), a.pick(streets))
+	case "refinance/city":
+		return a.pick(cities)
+	case "refinance/state", "property/state":
+		return a.pick(stateCodes)
+	case "refinance/zipcode":
+		return strconv.Itoa(10000 + a.rnd.Intn(89999))
+	case "property/value":
+		text := a.amount(150000, 1200000, 1000)
+		a.value, _ = strconv.ParseFloat(text, 64)
+		return text
+	case "loan-amount":
+		return strconv.FormatFloat(float64(int(a.value*(0.5+0.45*a.rnd.Float64())/1000)*1000), 'f', 0, 64)
+	case "institution":
+		return a.pick(banks)
+	case "creditor":
+		return a.pick(lenders)
+	case "balance":
+		return a.amount(500, 200000, 100)
+	case "monthly-payment":
+		return a.amount(50, 1500, 10)
+	case "proposed-payment":
+		if a.rnd.Float64() < 0.1 {
+			return ""
+		}
+		return a.amount(800, 5000, 10)
+	}
+
+	switch q.Type {
+	case YESNO:
+		odds, ok := yesOdds[form]
+		if !ok {
+			odds = 0.5
+		}
+		if a.rnd.Float64() < odds {
+			return "yes"
+		}
+		return "no"
+	case CHOICE:
+		return strconv.Itoa(1 + a.rnd.Intn(len(q.Options)))
+	case INTEGER:
+		return strconv.Itoa(int(q.Min) + a.rnd.Intn(100))
+	case MONEY:
+		return a.amount(100, 10000, 10)
+	case DATE:
+		return a.month(5).Format("01/2006")
+	}
+	return "Test"
+}
+
+// invalidAnswer
+//
+// Answer to q which fails validation, if there is one
+func (a *applicant) invalidAnswer(q *Question) (string, bool) {
+	switch q.Type {
+	case TEXT:
+		switch {
+		case q.Pattern != "":
+			return "??", true
+		case q.Path == "email":
+			return "not-an-email", true
+		case q.Path == "tax-id":
+			return "666-12-3456", true
+		case !q.Optional:
+			return "", true
+		}
+	case INTEGER:
+		return "many", true
+	case MONEY:
+		return "lots", true
+	case DATE:
+		if q.Path == "date-of-birth" {
+			// Too young to borrow
+			return time.Now().AddDate(-10, 0, 0).Format("01/02/2006"), true
+		}
+		return "13/2020", true
+	case CHOICE:
+		return strconv.Itoa(len(q.Options) + 1), true
+	}
+	return "", false
+}
+
+// next
+//
+// Answer to q as given by the applicant: now and then an invalid one
+func (a *applicant) next(form string, q *Question) string {
+	a.answers++
+	if a.rnd.Float64() < a.invalid {
+		if text, ok := a.invalidAnswer(q); ok {
+			return text
+		}
+	}
+	return a.answer(form, q)
+}
+
+func (a *applicant) Ask(form *Form, data interface{}, lock sync.Locker) error {
+	t := &typist{applicant: a, form: form}
+	return form.Ask(bufio.NewScanner(t), t, data, lock)
+}
+
+func (a *applicant) Print(text string) {}
+
+// typist
+//
+// Applicant at the keyboard of a form: the prompts written tell which
+// question the next line read answers, and the retries which answer was
+// invalid. After maxInvalid answers to a question, the input ends as the
+// applicant gives up.
+type typist struct {
+	*applicant
+	form     *Form
+	question *Question
+	tries    int
+}
+
+func (t *typist) Write(p []byte) (int, error) {
+	text := string(p)
+	if strings.Contains(text, "please try again!") {
+		t.invalids++
+		return len(p), nil
+	}
+	for _, q := range t.form.Questions {
+		if text == q.prompt()+" " && q != t.question {
+			t.question, t.tries = q, 0
+			break
+		}
+	}
+	return len(p), nil
+}
+
+// Read
+//
+// Answer to the question prompted, a line at a time
+func (t *typist) Read(p []byte) (int, error) {
+	if t.question == nil || t.tries == maxInvalid {
+		return 0, io.EOF
+	}
+	t.tries++
+	line := t.next(t.form.Name, t.question) + "\n"
+	if len(line) > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(p, line), nil
+}
+
+// coverage
+//
+// Steps run and transitions taken by the simulated applications, by
+// workflow, along with the errors of the tasks. A transition is taken
+// when its task enables the target while it runs.
+type coverage struct {
+	mu         sync.Mutex
+	reached    map[string]int // by "workflow/step"
+	taken      map[string]int // by "workflow/from -> to"
+	errors     map[string]int // by "workflow/step: error"
+	before     map[running]map[string]workflow.State
+	statuses   map[string]int
+	answers    int
+	invalids   int
+	abandoned  int
+	simulating bool
+}
+
+// running
+//
+// Task running in a run
+type running struct {
+	run  *workflow.Run
+	task string
+}
+
+func newCoverage() *coverage {
+	return &coverage{
+		reached:  map[string]int{},
+		taken:    map[string]int{},
+		errors:   map[string]int{},
+		before:   map[running]map[string]workflow.State{},
+		statuses: map[string]int{},
+	}
+}
+
+// step
+//
+// Step name of workflow workName
+func step(workName, name string) *workflow.Task {
+	steps, _ := workflow.Lookup(workName)
+	for _, s := range steps {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Listen
+//
+// Workflow listener recording the steps reached, the transitions taken
+// and the tasks which failed
+func (c *coverage) Listen(r *workflow.Run, ev *workflow.Event) {
+	if ev.Task == "" {
+		return
+	}
+	s := step(ev.Workflow, ev.Task)
+	if s == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.simulating {
+		return
+	}
+	key := ev.Workflow + "/" + ev.Task
+
+	switch ev.Type {
+	case workflow.TaskStarted:
+		c.reached[key]++
+		states := map[string]workflow.State{}
+		for _, t := range s.Branches() {
+			states[t.To] = r.State(t.To)
+		}
+		c.before[running{r, ev.Task}] = states
+	case workflow.TaskCompleted, workflow.TaskWaiting, workflow.TaskFailed:
+		if ev.Type == workflow.TaskFailed && !errors.Is(ev.Err, errAbandoned) {
+			c.errors[fmt.Sprintf("%s: %v", key, ev.Err)]++
+		}
+		for _, t := range s.Branches() {
+			if before := c.before[running{r, ev.Task}][t.To]; before != workflow.Enabled && r.State(t.To) == workflow.Enabled {
+				c.taken[key+" -> "+t.To]++
+			}
+		}
+	}
+}
+
+// walk
+//
+// Call fn on each step of workflow workName and its sub-workflows
+func walk(workName string, seen map[string]bool, fn func(workName string, s *workflow.Task)) {
+	if seen[workName] {
+		return
+	}
+	seen[workName] = true
+	steps, _ := workflow.Lookup(workName)
+	for _, s := range steps {
+		fn(workName, s)
+		if s.Workflow != "" {
+			walk(s.Workflow, seen, fn)
+		}
+	}
+}
+
+// write
+//
+// Report of the coverage of workflow workName. unreached tells the
+// steps and transitions never exercised.
+func (c *coverage) write(workName string) (unreached int, err error) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	never := []string{}
+	fmt.Fprintln(w, "STEP\tREACHED")
+	walk(workName, map[string]bool{}, func(wf string, s *workflow.Task) {
+		key := wf + "/" + s.Name
+		fmt.Fprintf(w, "%s\t%d\n", key, c.reached[key])
+		if c.reached[key] == 0 {
+			never = append(never, "step "+key)
+		}
+	})
+	fmt.Fprintln(w, "\t")
+	fmt.Fprintln(w, "TRANSITION\tTAKEN")
+	walk(workName, map[string]bool{}, func(wf string, s *workflow.Task) {
+		for _, t := range s.Branches() {
+			key := wf + "/" + s.Name + " -> " + t.To
+			fmt.Fprintf(w, "%s (%s)\t%d\n", key, t.When, c.taken[key])
+			if c.taken[key] == 0 {
+				never = append(never, "transition "+key)
+			}
+		}
+	})
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+
+	if len(never) > 0 {
+		fmt.Println("\nNEVER EXERCISED")
+		for _, s := range never {
+			fmt.Printf("  %s\n", s)
+		}
+	}
+	if len(c.errors) > 0 {
+		fmt.Println("\nTASK ERRORS")
+		keys := make([]string, 0, len(c.errors))
+		for k := range c.errors {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("  %s (%d)\n", k, c.errors[k])
+		}
+	}
+	return len(never), nil
+}
+
+// simulateApplication
+//
+// Run an application of workflow workName answered by a, then play the
+// loan officer and the third parties until it is done: decisions, with
+// changes requested once at most, and reports, some of them overdue
+func simulateApplication(id, workName string, a *applicant) (*Context, error) {
+	ctx, err := newContext(workName)
+	if err != nil {
+		return nil, err
+	}
+	ctx.ID, ctx.Serial, ctx.ui = id, true, a
+
+	changes := false
+	for round := 0; round < 10; round++ {
+		err := ctx.Execute()
+		if err == nil || !errors.Is(err, workflow.ErrWaiting) {
+			return ctx, err
+		}
+
+		switch {
+		case ctx.AwaitingReview():
+			r := &Review{Officer: "simulation", Decided: time.Now()}
+			switch p := a.rnd.Float64(); {
+			case p < 0.2 && !changes:
+				reopen := []string{"loanType", "loanTerms", "coborrower", "assets", "liabilities"}
+				r.Decision, r.Notes, r.Reopen = CHANGES, "Please review your answers", a.pick(reopen)
+				changes = true
+			case p < 0.4:
+				r.Decision = DECLINE
+			default:
+				r.Decision = APPROVE
+			}
+			if err := ctx.Decide(r); err != nil {
+				return ctx, err
+			}
+			if err := ctx.resumeReview(); err != nil {
+				return ctx, err
+			}
+		case ctx.Status() == "approved":
+			if err := a.report(ctx); err != nil {
+				return ctx, err
+			}
+		default:
+			return ctx, err
+		}
+	}
+	return ctx, errors.New("application did not settle")
+}
+
+// report
+//
+// Play the third parties the approved application waits for: most
+// report, the others let their deadline pass and report once followed up
+func (a *applicant) report(ctx *Context) error {
+	steps, _ := workflow.Lookup(ctx.WorkFlow)
+	for _, s := range steps {
+		if s.Signal == "" || ctx.State(s.Name) != workflow.Waiting {
+			continue
+		}
+		if a.rnd.Float64() < 0.2 && s.Timeout > 0 && !a.overdue[s.Name] {
+			// Reported late, once followed up
+			a.overdue[s.Name] = true
+			ctx.Lock()
+			ctx.Waits[s.Name] = time.Now().Add(-s.Timeout - time.Minute)
+			ctx.Unlock()
+			continue
+		}
+
+		var payload interface{}
+		switch s.Signal {
+		case "appraisal":
+			ctx.Lock()
+			value := ctx.LoanAmount
+			if ctx.Property != nil {
+				value = ctx.Property.Value
+			}
+			ctx.Unlock()
+			payload = &Appraisal{Value: float64(int(value * (0.85 + 0.25*a.rnd.Float64()))), Appraiser: "simulation"}
+		case "title":
+			t := &TitleSearch{Clear: a.rnd.Float64() < 0.9}
+			if !t.Clear {
+				t.Liens = []string{"unpaid property taxes"}
+			}
+			payload = t
+		case "employment-verification":
+			payload = &Verification{Employer: a.pick(companies), Verified: a.rnd.Float64() < 0.9}
+		default:
+			continue
+		}
+		buf, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := ctx.Signal(s.Signal, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simulate
+//
+// `simulate` command: run synthetic applicants through a workflow and
+// report the steps and transitions they exercised, and the task errors,
+// or load-test a server with them
+func simulate(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	n := flags.Int("n", 100, "number of applicants")
+	seed := flags.Int64("seed", 1, "seed of the applicants drawn")
+	workName := flags.String("workflow", "newAccount", "workflow to run the applicants through")
+	invalid := flags.Float64("invalid", 0.05, "odds of an invalid answer")
+	strict := flags.Bool("strict", false, "fail when a step or transition is never exercised")
+	server := flags.String("server", "", "URL of a server to load-test through its web forms")
+	concurrency := flags.Int("concurrency", 8, "applicants filling out forms at once on the server")
+	verbose := flags.Bool("v", false, "log the follow-ups and reminders of the applications")
+	flags.Parse(args)
+
+	if *server != "" {
+		return loadTest(*server, *n, *concurrency, *seed, *invalid)
+	}
+	if _, ok := workflow.Lookup(*workName); !ok {
+		return fmt.Errorf("invalid workflow '%s'", *workName)
+	}
+
+	// Synthetic applications must not reach real applicants' data
+	offline()
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	c := newCoverage()
+	workflow.Listen(c.Listen)
+
+	start := time.Now()
+	for i := 0; i < *n; i++ {
+		a := newApplicant(*seed+int64(i), *invalid)
+		c.mu.Lock()
+		c.simulating = true
+		c.mu.Unlock()
+		ctx, err := simulateApplication(fmt.Sprintf("sim-%d", i+1), *workName, a)
+		c.mu.Lock()
+		c.simulating = false
+		c.before = map[running]map[string]workflow.State{}
+		c.answers += a.answers
+		c.invalids += a.invalids
+		switch {
+		case errors.Is(err, errAbandoned):
+			c.abandoned++
+		case err != nil:
+			c.errors[fmt.Sprintf("%s: %v", *workName, err)]++
+		}
+		if ctx != nil {
+			c.statuses[ctx.Status()]++
+		}
+		c.mu.Unlock()
+	}
+
+	counts := []string{}
+	for _, s := range statuses {
+		if c.statuses[s] > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", c.statuses[s], s))
+		}
+	}
+	fmt.Printf("%d applicant(s) through %s in %v (seed %d): %s\n", *n, *workName,
+		time.Since(start).Round(time.Millisecond), *seed, strings.Join(counts, ", "))
+	fmt.Printf("%d answer(s), %d invalid and retried, %d applicant(s) gave up\n\n", c.answers, c.invalids, c.abandoned)
+
+	unreached, err := c.write(*workName)
+	if err != nil {
+		return err
+	}
+	if len(c.errors) > 0 {
+		return fmt.Errorf("%d task error(s)", len(c.errors))
+	}
+	if *strict && unreached > 0 {
+		return fmt.Errorf("%d step(s) or transition(s) never exercised", unreached)
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// dataDir
+//
+// Directory holding saved applications. Saving is disabled when empty.
+var dataDir = "applications"
+
+// storeKind
+//
+// Storage of the applications within dataDir: "json" for a JSON file per
+// application, "db" for a single-file database
+var storeKind = "json"
+
+// ErrConflict
+//
+// Returned when saving an application which another writer saved since
+// it was loaded
+var ErrConflict = errors.New("application was modified by another writer")
+
+// Store
+//
+// Persistence of applications. Save fails with ErrConflict unless the
+// version of the application is the one stored, then increments it.
+// Load fails with an error matching os.ErrNotExist for an unknown one.
+type Store interface {
+	Save(ctx *Context) error
+	Load(id string) (*Context, error)
+	List(q *Query) ([]*Context, error)
+}
+
+// Query
+//
+// Criteria to select applications, empty ones match any application:
+// status (see Status), workflow, loan type and creation date in [From, To)
+type Query struct {
+	Status   string
+	Workflow string
+	LoanType string
+	From     time.Time
+	To       time.Time
+}
+
+// Match
+//
+// Whether an application, given by its summary, matches the query
+func (q *Query) Match(s *summary) bool {
+	switch {
+	case q == nil:
+		return true
+	case q.Status != "" && q.Status != s.Status:
+		return false
+	case q.Workflow != "" && q.Workflow != s.Workflow:
+		return false
+	case q.LoanType != "" && q.LoanType != s.LoanType:
+		return false
+	case !q.From.IsZero() && s.Created.Before(q.From):
+		return false
+	case !q.To.IsZero() && !s.Created.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// statuses of applications, see Status
+var statuses = []string{"open", "review", "approved", "declined", "expired"}
+
+// parseQuery
+//
+// Query given as text, dates in YYYY-MM-DD format
+func parseQuery(status, workName, loan, from, to string) (*Query, error) {
+	q := &Query{Status: status, Workflow: workName, LoanType: loan}
+	if status != "" && !contains(statuses, status) {
+		return nil, fmt.Errorf("invalid status '%s', expecting one of %s", status, strings.Join(statuses, ", "))
+	}
+	if loan != "" && loan != PURCHASE.String() && loan != REFINANCE.String() {
+		return nil, fmt.Errorf("invalid loan type '%s'", loan)
+	}
+	for _, d := range []struct {
+		text string
+		t    *time.Time
+	}{{from, &q.From}, {to, &q.To}} {
+		if d.text == "" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", d.text, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date '%s', expecting YYYY-MM-DD", d.text)
+		}
+		*d.t = t
+	}
+	return q, nil
+}
+
+// summary
+//
+// Fields of an application which queries select on
+type summary struct {
+	Status   string    `json:"status"`
+	Workflow string    `json:"workflow"`
+	LoanType string    `json:"loan-type"`
+	Created  time.Time `json:"created"`
+}
+
+// Status
+//
+// Stage of the application: "open" while the applicant fills it out,
+// "review" while it awaits a loan officer, then "approved" or "declined",
+// or "expired"
+func (ctx *Context) Status() string {
+	switch {
+	case ctx.Expired != nil:
+		return "expired"
+	case ctx.State("declined") == workflow.Completed:
+		return "declined"
+	case ctx.State("approved") == workflow.Completed:
+		return "approved"
+	case ctx.AwaitingReview():
+		return "review"
+	}
+	return "open"
+}
+
+// summary
+//
+// Queried fields of the application
+func (ctx *Context) summary() *summary {
+	s := &summary{Status: ctx.Status(), LoanType: ctx.LoanType.String(), Created: ctx.Created}
+	if ctx.Run != nil {
+		s.Workflow = ctx.WorkFlow
+	}
+	return s
+}
+
+var (
+	storeMu sync.Mutex
+	stores  = map[string]Store{}
+)
+
+// storage
+//
+// Store of the applications selected by dataDir and storeKind, opened
+// once per process
+func storage() (Store, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	key := storeKind + ":" + dataDir
+	if s, ok := stores[key]; ok {
+		return s, nil
+	}
+
+	var s Store
+	switch storeKind {
+	case "json":
+		s = &jsonStore{dir: dataDir}
+	case "db":
+		db, err := openDB(filepath.Join(dataDir, "applications.db"))
+		if err != nil {
+			return nil, err
+		}
+		s = db
+	default:
+		return nil, fmt.Errorf("invalid store '%s', expecting json or db", storeKind)
+	}
+	stores[key] = s
+	return s, nil
+}
+
+// newID
+//
+// Generate a random application ID
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Save
+//
+// Persist context in the store, unless saving is disabled
+func (ctx *Context) Save() error {
+	if dataDir == "" {
+		return nil
+	}
+	s, err := storage()
+	if err != nil {
+		return err
+	}
+	return s.Save(ctx)
+}
+
+// marshal
+//
+// Encode the context as saved with its next version. rollback restores
+// the version when the write fails.
+func (ctx *Context) marshal() (buf []byte, rollback func(), err error) {
+	ctx.Lock()
+	defer ctx.Unlock()
+	ctx.Updated = time.Now()
+	ctx.Version++
+	version := ctx.Version
+	if buf, err = json.MarshalIndent(ctx, "", "  "); err != nil {
+		ctx.Version--
+		return nil, nil, err
+	}
+	rollback = func() {
+		ctx.Lock()
+		defer ctx.Unlock()
+		if ctx.Version == version {
+			ctx.Version--
+		}
+	}
+	return buf, rollback, nil
+}
+
+// unmarshalContext
+//
+// Decode a saved application
+func unmarshalContext(buf []byte) (*Context, error) {
+	ctx := &Context{}
+	if err := json.Unmarshal(buf, ctx); err != nil {
+		return nil, err
+	}
+	if ctx.Run == nil {
+		ctx.Run = &workflow.Run{}
+	}
+	ctx.Data = ctx
+	return ctx, nil
+}
+
+// loadContext
+//
+// Read saved application id
+func loadContext(id string) (*Context, error) {
+	s, err := storage()
+	if err != nil {
+		return nil, err
+	}
+	return s.Load(id)
+}
+
+// appLock
+//
+// Lock of an application, shared by its writers in the process
+type appLock struct {
+	sync.Mutex
+	users int
+}
+
+var (
+	appLocksMu sync.Mutex
+	appLocks   = map[string]*appLock{}
+)
+
+// lockApplication
+//
+// Take the lock of application id, returning the function releasing it.
+// The writers of a server, web forms, the loan officers' API and the
+// background timers, load and run an application holding its lock, so
+// that none of them runs a version out of date.
+func lockApplication(id string) (unlock func()) {
+	appLocksMu.Lock()
+	l, ok := appLocks[id]
+	if !ok {
+		l = &appLock{}
+		appLocks[id] = l
+	}
+	l.users++
+	appLocksMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		appLocksMu.Lock()
+		defer appLocksMu.Unlock()
+		if l.users--; l.users == 0 {
+			delete(appLocks, id)
+		}
+	}
+}
+
+// loadApplications
+//
+// Read the saved applications matching q, nil for all, ordered by
+// creation time
+func loadApplications(q *Query) ([]*Context, error) {
+	s, err := storage()
+	if err != nil {
+		return nil, err
+	}
+	return s.List(q)
+}
+
+// sortApplications
+//
+// Order applications by creation time
+func sortApplications(apps []*Context) {
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Created.Before(apps[j].Created)
+	})
+}
+
+// Time to wait for a lock file, and age of a lock left behind
+const (
+	lockWait  = 5 * time.Second
+	lockStale = 30 * time.Second
+)
+
+// lockFile
+//
+// Take the lock file at path, shared with other processes, waiting up to
+// lockWait for it. A lock older than lockStale was left behind by a
+// crashed process and is broken.
+func lockFile(path string) (unlock func(), err error) {
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// jsonStore
+//
+// Store keeping each application as `<dir>/<id>.json`
+type jsonStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Save
+//
+// Write the application to a temporary file first so a crash never
+// leaves a truncated application behind
+func (s *jsonStore) Save(ctx *Context) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := filepath.Join(s.dir, ctx.ID+".json")
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stored := 0
+	if saved, err := s.Load(ctx.ID); err == nil {
+		stored = saved.Version
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ctx.Lock()
+	version := ctx.Version
+	ctx.Unlock()
+	if stored != version {
+		return fmt.Errorf("application %s version %d, stored %d: %w", ctx.ID, version, stored, ErrConflict)
+	}
+
+	buf, rollback, err := ctx.marshal()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", buf, 0600); err != nil {
+		rollback()
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+// Load
+//
+// Read application id
+func (s *jsonStore) Load(id string) (*Context, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(s.dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalContext(buf)
+}
+
+// List
+//
+// Read the applications matching q, ordered by creation time
+func (s *jsonStore) List(q *Query) ([]*Context, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := []*Context{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		ctx, err := s.Load(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if q.Match(ctx.summary()) {
+			apps = append(apps, ctx)
+		}
+	}
+	sortApplications(apps)
+	return apps, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveConflictStopsRun(t *testing.T) {
+	offline()
+	defer offline()
+	dataDir = t.TempDir()
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	t.Setenv("TAX_ID_KEY", key)
+	t.Setenv("SIGNATURE_KEY", key)
+
+	ctx, err := newContext("newAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Serial = true
+	if err := ctx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// Another writer saves the application meanwhile
+	other, err := loadContext(ctx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := readScript(filepath.Join("testdata", "golden", "purchase.in"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, _ = expandMonths(script, time.Now())
+	ui := newScripted(script)
+	ctx.ui = ui
+	if err := ctx.Execute(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v, expecting %v", err, ErrConflict)
+	}
+	// The first task is left as it started, no further one runs
+	if strings.Contains(ui.out.String(), "loan") {
+		t.Errorf("run went on after the conflict:\n%s", ui.out)
+	}
+	saved, err := loadContext(ctx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != other.Version {
+		t.Errorf("saved version %d, expecting %d of the other writer", saved.Version, other.Version)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Superseded records the database file may hold before it is compacted
+const compactMin = 64
+
+// dbRecord
+//
+// Line of the database file: an application as saved, along with its
+// version and the fields queries select on
+type dbRecord struct {
+	ID      string          `json:"id"`
+	Version int             `json:"version"`
+	Summary *summary        `json:"summary"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// dbStore
+//
+// Embedded database keeping every application in a single file. Saving
+// appends a record to the file, the last record of an application being
+// its current version; the file is compacted once superseded records
+// outnumber live ones. Records are indexed in memory, and records appended
+// by other processes are read before every operation.
+type dbStore struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]*dbRecord
+	file    os.FileInfo // file the records were read from
+	offset  int64       // end of the last complete record read
+	garbage int         // superseded records in the file
+}
+
+// openDB
+//
+// Open the database file at path, created on the first save
+func openDB(path string) (*dbStore, error) {
+	s := &dbStore{path: path}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// refresh
+//
+// Read the records appended since the last read, or the whole file when
+// it was replaced by a compaction. An incomplete last record, left by a
+// writer which crashed, is ignored. Must be called with the lock held.
+func (s *dbStore) refresh() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		s.records, s.file, s.offset, s.garbage = map[string]*dbRecord{}, nil, 0, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if s.file == nil || !os.SameFile(s.file, fi) || fi.Size() < s.offset {
+		s.records, s.offset, s.garbage = map[string]*dbRecord{}, 0, 0
+	}
+	s.file = fi
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec := &dbRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return fmt.Errorf("%s: corrupt record at offset %d: %v", s.path, s.offset, err)
+		}
+		if _, ok := s.records[rec.ID]; ok {
+			s.garbage++
+		}
+		s.records[rec.ID] = rec
+		s.offset += int64(len(line))
+	}
+}
+
+// Save
+//
+// Append the application to the file
+func (s *dbStore) Save(ctx *Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	stored := 0
+	if rec, ok := s.records[ctx.ID]; ok {
+		stored = rec.Version
+	}
+	ctx.Lock()
+	version := ctx.Version
+	ctx.Unlock()
+	if stored != version {
+		return fmt.Errorf("application %s version %d, stored %d: %w", ctx.ID, version, stored, ErrConflict)
+	}
+
+	buf, rollback, err := ctx.marshal()
+	if err != nil {
+		return err
+	}
+	rec := &dbRecord{ID: ctx.ID, Version: version + 1, Summary: ctx.summary(), Data: buf}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		rollback()
+		return err
+	}
+	if err := s.append(append(line, '\n')); err != nil {
+		rollback()
+		return err
+	}
+	if _, ok := s.records[rec.ID]; ok {
+		s.garbage++
+	}
+	s.records[rec.ID] = rec
+	if s.garbage > compactMin && s.garbage > len(s.records) {
+		return s.compact()
+	}
+	return nil
+}
+
+// append
+//
+// Write a record after the last complete one, discarding an incomplete
+// record, and flush it to disk
+func (s *dbStore) append(line []byte) error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(s.offset); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(line, s.offset); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if s.file, err = f.Stat(); err != nil {
+		return err
+	}
+	s.offset += int64(len(line))
+	return nil
+}
+
+// compact
+//
+// Rewrite the file with the current version of each application only
+func (s *dbStore) compact() error {
+	ids := make([]string, 0, len(s.records))
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, id := range ids {
+		if err := enc.Encode(s.records[id]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	// Read the compacted file back as other processes will
+	s.file = nil
+	return s.refresh()
+}
+
+// Load
+//
+// Current version of application id
+func (s *dbStore) Load(id string) (*Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	rec, ok := s.records[id]
+	if !ok {
+		return nil, fmt.Errorf("application %s: %w", id, os.ErrNotExist)
+	}
+	return unmarshalContext(rec.Data)
+}
+
+// List
+//
+// Applications matching q, ordered by creation time. Records are
+// selected on their summary before being decoded.
+func (s *dbStore) List(q *Query) ([]*Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+
+	apps := []*Context{}
+	for _, rec := range s.records {
+		if !q.Match(rec.Summary) {
+			continue
+		}
+		ctx, err := unmarshalContext(rec.Data)
+		if err != nil {
+			return nil, fmt.Errorf("application %s: %v", rec.ID, err)
+		}
+		apps = append(apps, ctx)
+	}
+	sortApplications(apps)
+	return apps, nil
+}
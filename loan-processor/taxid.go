@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// Social Security number or ITIN, e.g. 123-45-6789 or 123456789
+var taxIDRE = regexp.MustCompile(`^(\d{3})-?(\d{2})-?(\d{4})$`)
+
+// TaxID
+//
+// Social Security number or ITIN of a borrower. Only its last 4 digits
+// are kept in clear, the number itself is sealed with AES-GCM.
+type TaxID struct {
+	Kind   string `json:"kind"` // SSN or ITIN
+	Last4  string `json:"last4"`
+	Sealed string `json:"sealed,omitempty"`
+	Number string `json:"number,omitempty"` // only in exports revealing it
+}
+
+// parseTaxID
+//
+// Digits and kind of a Social Security number or ITIN. SSNs cannot start
+// with 000, 666 or 9, nor have a group of 00 or a serial of 0000. ITINs
+// start with 9 and have a group within 50-65, 70-88, 90-92 or 94-99.
+// Errors do not repeat the number.
+func parseTaxID(text string) (digits, kind string, err error) {
+	m := taxIDRE.FindStringSubmatch(text)
+	if m == nil {
+		return "", "", errors.New("Invalid number, use XXX-XX-XXXX")
+	}
+	area, _ := strconv.Atoi(m[1])
+	group, _ := strconv.Atoi(m[2])
+	digits = m[1] + m[2] + m[3]
+	switch {
+	case area >= 900:
+		if group >= 50 && group <= 65 || group >= 70 && group <= 88 || group >= 90 && group <= 92 || group >= 94 {
+			return digits, "ITIN", nil
+		}
+		return "", "", errors.New("Invalid ITIN")
+	case area == 0 || area == 666 || group == 0 || m[3] == "0000":
+		return "", "", errors.New("Invalid Social Security number")
+	}
+	return digits, "SSN", nil
+}
+
+// taxIDCheck
+//
+// Check of the answer to a tax ID question
+func taxIDCheck(data, value interface{}) error {
+	_, _, err := parseTaxID(value.(string))
+	return err
+}
+
+// setText
+//
+// Seal the number answered
+func (id *TaxID) setText(text string) error {
+	digits, kind, err := parseTaxID(text)
+	if err != nil {
+		return err
+	}
+	sealed, err := seal([]byte(digits))
+	if err != nil {
+		return fmt.Errorf("unable to seal tax ID: %v", err)
+	}
+	*id = TaxID{Kind: kind, Last4: digits[5:], Sealed: sealed}
+	return nil
+}
+
+// TaxID Print, masked
+func (id *TaxID) String() string {
+	return fmt.Sprintf("***-**-%s (%s)", id.Last4, id.Kind)
+}
+
+// Reveal
+//
+// Number sealed, as XXX-XX-XXXX
+func (id *TaxID) Reveal() (string, error) {
+	digits, err := unseal(id.Sealed)
+	if err != nil {
+		return "", fmt.Errorf("unable to unseal tax ID: %v", err)
+	}
+	if len(digits) != 9 {
+		return "", errors.New("unable to unseal tax ID: invalid number")
+	}
+	return fmt.Sprintf("%s-%s-%s", digits[:3], digits[3:5], digits[5:]), nil
+}
+
+// Key sealing the tax IDs
+var taxIDKey = &serverKey{use: "seal tax IDs", env: "TAX_ID_KEY", fileEnv: "TAX_ID_KEY_FILE"}
+
+// sealer
+//
+// AES-GCM with the key sealing the tax IDs
+func sealer() (cipher.AEAD, error) {
+	key, err := taxIDKey.load()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal
+//
+// Encrypt plain with a random nonce, in base64 with the nonce first
+func seal(plain []byte) (string, error) {
+	gcm, err := sealer()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// unseal
+//
+// Decrypt what seal encrypted
+func unseal(sealed string) (string, error) {
+	gcm, err := sealer()
+	if err != nil {
+		return "", err
+	}
+	buf, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(buf) < gcm.NonceSize() {
+		return "", errors.New("invalid sealed value")
+	}
+	plain, err := gcm.Open(nil, buf[:gcm.NonceSize()], buf[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("wrong key or corrupted value")
+	}
+	return string(plain), nil
+}
+
+// exportedClient
+//
+// Copy of a client to export, without its sealed tax ID, or with its
+// number revealed
+func exportedClient(c *Client, reveal bool) (*Client, error) {
+	if c == nil || c.TaxID == nil {
+		return c, nil
+	}
+	copied, id := *c, *c.TaxID
+	id.Sealed = ""
+	if reveal {
+		number, err := c.TaxID.Reveal()
+		if err != nil {
+			return nil, err
+		}
+		id.Number = number
+	}
+	copied.TaxID = &id
+	return &copied, nil
+}
+
+// exported
+//
+// Copy of the application to export, e.g. to the loan officer API or
+// webhooks: the tax IDs are masked, or revealed when authorized
+func (ctx *Context) exported(reveal bool) (*Context, error) {
+	copied := *ctx
+	var err error
+	if copied.Client, err = exportedClient(ctx.Client, reveal); err != nil {
+		return nil, err
+	}
+	copied.CoBorrow = make([]*Client, len(ctx.CoBorrow))
+	for i, c := range ctx.CoBorrow {
+		if copied.CoBorrow[i], err = exportedClient(c, reveal); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.CoBorrow == nil {
+		copied.CoBorrow = nil
+	}
+	return &copied, nil
+}
+
+// Export
+//
+// Application as exported to webhooks, with the tax IDs masked
+func (ctx *Context) Export() interface{} {
+	copied, _ := ctx.exported(false)
+	return copied
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
-	"sync"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
 )
 
 const (
@@ -10,34 +12,105 @@ const (
 	REFINANCE
 )
 
-type loanType int
-type taskHandler func(context *Context) error
+const (
+	NOTEMPLOYED employmentType = iota
+	W2
+	SELFEMPLOYED
+	RETIRED
+)
 
-type TaskFunc struct {
-	Kind    string
-	wg      *sync.WaitGroup
-	Handler taskHandler
-	Context *Context
-	Error   error
-}
+const (
+	NOASSET assetType = iota
+	CHECKING
+	SAVINGS
+	INVESTMENT
+	RETIREMENT
+)
 
-type Task struct {
-	Name  string
-	State string
-}
+const (
+	NODEBT liabilityType = iota
+	CREDITCARD
+	AUTOLOAN
+	STUDENTLOAN
+	MORTGAGE
+	OTHERDEBT
+)
+
+const (
+	MISSING docStatus = iota
+	RECEIVED
+	REJECTED
+)
+
+const (
+	NOOCCUPANCY occupancyType = iota
+	PRIMARY
+	SECONDHOME
+	INVESTMENTPROPERTY
+)
+
+const (
+	NODECISION decision = iota
+	APPROVE
+	DECLINE
+	CHANGES
+)
+
+type loanType int
+type employmentType int
+type assetType int
+type liabilityType int
+type docStatus int
+type occupancyType int
+type decision int
+type taskHandler func(context *Context) error
 
 type Context struct {
-	Client    *Client    `json:"client"`
-	LoanType  loanType   `json:"loan-type"`
-	Refinance *Refinance `json:"refinance"`
-	CoBorrow  *Client    `json:"co-borrower,omitempty"`
-	stateMap  map[string]string
-	WorkFlow  string `json:"work-flow"`
+	ID              string        `json:"id"`
+	Client          *Client       `json:"client"`
+	LoanType        loanType      `json:"loan-type"`
+	Refinance       *Refinance    `json:"refinance"`
+	Property        *Property     `json:"property,omitempty"`
+	LoanAmount      float64       `json:"loan-amount,omitempty"`
+	CoBorrow        []*Client     `json:"co-borrowers,omitempty"`
+	Assets          []*Asset      `json:"assets,omitempty"`
+	Liabilities     []*Liability  `json:"liabilities,omitempty"`
+	ProposedPayment float64       `json:"proposed-payment,omitempty"`
+	CreditConsent   *time.Time    `json:"credit-consent,omitempty"`
+	Credit          *CreditReport `json:"credit,omitempty"`
+	CreditError     string        `json:"credit-error,omitempty"`
+	CreditAttempts  int           `json:"credit-attempts,omitempty"`
+	Documents       []*Document   `json:"documents,omitempty"`
+	Quotes          []*Quote      `json:"quotes,omitempty"`
+	Signatures      []*Signature  `json:"signatures,omitempty"`
+	Submitted       *time.Time    `json:"submitted,omitempty"`
+	Reviews         []*Review     `json:"reviews,omitempty"`
+	Appraisal       *Appraisal    `json:"appraisal,omitempty"`
+	Title           *TitleSearch  `json:"title,omitempty"`
+	Verification    *Verification `json:"employment-verification,omitempty"`
+	Version         int           `json:"version"`
+	Created         time.Time     `json:"created"`
+	Updated         time.Time     `json:"updated"`
+	*workflow.Run
+	ui frontend // asks the questions, the terminal when nil
 }
 
 type Client struct {
-	Name string `json:"full-name"`
-	Age  int    `json:"age"`
+	Name       string        `json:"full-name"`
+	Birth      *time.Time    `json:"date-of-birth,omitempty"`
+	Age        int           `json:"age,omitempty"` // given before the date of birth was asked
+	TaxID      *TaxID        `json:"tax-id,omitempty"`
+	Email      string        `json:"email,omitempty"`
+	Employment []*Employment `json:"employment,omitempty"`
+}
+
+type Employment struct {
+	Type          employmentType `json:"type"`
+	Employer      string         `json:"employer,omitempty"`
+	Position      string         `json:"position,omitempty"`
+	Start         time.Time      `json:"start-date"`
+	End           *time.Time     `json:"end-date,omitempty"`
+	MonthlyIncome float64        `json:"monthly-income"`
 }
 
 type Refinance struct {
@@ -46,3 +119,88 @@ type Refinance struct {
 	State   string `json:"state"`
 	ZipCode int    `json:"zipcode"`
 }
+
+type Property struct {
+	State     string        `json:"state"`
+	Value     float64       `json:"value"`
+	Occupancy occupancyType `json:"occupancy"`
+}
+
+type Asset struct {
+	Type        assetType `json:"type"`
+	Institution string    `json:"institution"`
+	Balance     float64   `json:"balance"`
+}
+
+type Liability struct {
+	Type           liabilityType `json:"type"`
+	Creditor       string        `json:"creditor"`
+	Balance        float64       `json:"balance"`
+	MonthlyPayment float64       `json:"monthly-payment"`
+}
+
+type CreditRequest struct {
+	Name    string    `json:"full-name"`
+	Age     int       `json:"age"`
+	TaxID   string    `json:"tax-id,omitempty"`
+	Consent time.Time `json:"consent"`
+}
+
+type CreditReport struct {
+	Bureau     string     `json:"bureau"`
+	Score      int        `json:"score"`
+	Pulled     time.Time  `json:"pulled"`
+	Tradelines Tradelines `json:"tradelines"`
+}
+
+type Tradelines struct {
+	Accounts        int     `json:"accounts"`
+	Open            int     `json:"open"`
+	Delinquent      int     `json:"delinquent"`
+	Balance         float64 `json:"balance"`
+	MonthlyPayments float64 `json:"monthly-payments"`
+}
+
+type Document struct {
+	Key      string     `json:"key"`
+	Name     string     `json:"name"`
+	Status   docStatus  `json:"status"`
+	File     string     `json:"file,omitempty"`
+	Hash     string     `json:"sha256,omitempty"`
+	Size     int64      `json:"size,omitempty"`
+	Received *time.Time `json:"received,omitempty"`
+	Reason   string     `json:"reason,omitempty"`
+}
+
+type Quote struct {
+	Product        string    `json:"product"`
+	Term           int       `json:"term"`
+	Rate           float64   `json:"rate"`
+	Points         float64   `json:"points"`
+	MonthlyPayment float64   `json:"monthly-payment"`
+	Quoted         time.Time `json:"quoted"`
+}
+
+type Review struct {
+	Decision decision  `json:"decision"`
+	Officer  string    `json:"officer"`
+	Notes    string    `json:"notes,omitempty"`
+	Reopen   string    `json:"reopen,omitempty"`
+	Decided  time.Time `json:"decided"`
+}
+
+type Appraisal struct {
+	Value     float64 `json:"value"`
+	Appraiser string  `json:"appraiser,omitempty"`
+}
+
+type TitleSearch struct {
+	Clear bool     `json:"clear"`
+	Liens []string `json:"liens,omitempty"`
+}
+
+type Verification struct {
+	Employer string `json:"employer"`
+	Verified bool   `json:"verified"`
+	Notes    string `json:"notes,omitempty"`
+}
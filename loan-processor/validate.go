@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// validate
+//
+// `validate` command: check the definition of registered workflows
+func validate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	workName := flags.String("workflow", "", "workflow to validate, all when empty")
+	flags.Parse(args)
+
+	names := workflow.Workflows()
+	if *workName != "" {
+		names = []string{*workName}
+	}
+
+	count := 0
+	for _, name := range names {
+		errs := workflow.Validate(name)
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		if len(errs) == 0 {
+			fmt.Printf("workflow '%s': ok\n", name)
+		}
+		count += len(errs)
+	}
+	if count > 0 {
+		return fmt.Errorf("%d workflow error(s)", count)
+	}
+	return nil
+}
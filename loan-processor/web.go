@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// webForm
+//
+// Front end answering the questions with the values posted from a web
+// page. The first form asked without answers is left pending for the
+// page to render, suspending the workflow until it is posted. Questions
+// which come to apply once the page is answered, e.g. those following the
+// choice of a type, are shown on the same page when it is posted again.
+type webForm struct {
+	mu       sync.Mutex
+	name     string            // form the answers were posted for
+	answers  map[string]string // posted answers by path, nil once used
+	pending  *Form
+	data     interface{}
+	errs     map[string]error
+	messages []string
+	version  int // of the application once executed
+}
+
+func (w *webForm) Ask(form *Form, data interface{}, lock sync.Locker) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending != nil {
+		return workflow.ErrWaiting
+	}
+	answers := w.answers
+	if answers == nil || w.name != form.Name {
+		w.pending, w.data = form, data
+		return workflow.ErrWaiting
+	}
+	w.answers = nil
+
+	w.mu.Unlock()
+	errs := form.Answer(data, answers, lock)
+	w.mu.Lock()
+	unanswered := false
+	for _, q := range form.Questions {
+		if _, ok := answers[q.Path]; !ok && q.Asked(data) {
+			unanswered = true
+		}
+	}
+	if len(errs) > 0 || unanswered {
+		w.pending, w.data, w.errs, w.answers = form, data, errs, answers
+		return workflow.ErrWaiting
+	}
+	return nil
+}
+
+func (w *webForm) Print(text string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if text = strings.TrimSpace(text); text != "" {
+		w.messages = append(w.messages, text)
+	}
+}
+
+// webQuestion
+//
+// Question as rendered on the page
+type webQuestion struct {
+	*Question
+	Label string
+	Value string
+	Hint  string
+	Error error
+}
+
+// webPage
+//
+// Page rendered for an application: its pending form, or its status
+type webPage struct {
+	ID       string
+	Version  int
+	Title    string
+	Form     *Form
+	Fields   []*webQuestion
+	Messages []string
+	Summary  string
+	Error    string
+	Resume   bool // the application is to be resumed to show its pending form
+}
+
+// page
+//
+// Page of the application once executed
+func (w *webForm) page(ctx *Context, title string) *webPage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ctx.Lock()
+	p := &webPage{ID: ctx.ID, Version: ctx.Version, Title: title, Messages: w.messages}
+	ctx.Unlock()
+	if w.pending == nil {
+		// Completed tasks print the summary themselves
+		if len(w.messages) == 0 {
+			p.Summary = ctx.String()
+		}
+		return p
+	}
+
+	p.Form = w.pending
+	for _, q := range w.pending.Questions {
+		if !q.Asked(w.data) {
+			continue
+		}
+		f := &webQuestion{Question: q, Label: q.Prompt, Value: q.Value(w.data), Error: w.errs[q.Path]}
+		if text, ok := w.answers[q.Path]; ok && !q.Secret {
+			f.Value = text
+		}
+		if q.Type == DATE {
+			layout := q.Layout
+			if layout == "" {
+				layout = "01/2006"
+			}
+			f.Hint = dateHint(layout)
+		}
+		p.Fields = append(p.Fields, f)
+	}
+	return p
+}
+
+// Template of the pages of the web front end
+var webTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"choice": func(i int) string { return strconv.Itoa(i + 1) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Loan application</title></head>
+<body>
+<h1>{{.Title}}</h1>
+{{- if .Error}}
+<p><strong>{{.Error}}</strong></p>
+{{- end}}
+{{- range .Messages}}
+<pre>{{.}}</pre>
+{{- end}}
+{{- if .Form}}
+<form method="post" action="/apply/{{.ID}}">
+<input type="hidden" name="form" value="{{.Form.Name}}">
+<input type="hidden" name="version" value="{{.Version}}">
+{{- range .Fields}}
+<input type="hidden" name="asked" value="{{.Path}}">
+{{- end}}
+{{- if .Form.Title}}
+<h2>{{.Form.Title}}</h2>
+{{- end}}
+{{- range .Fields}}
+<p>
+{{- if eq .Type "yes/no"}}
+<span>{{.Label}}</span>
+<label><input type="radio" name="{{.Path}}" value="yes"{{if eq .Value "yes"}} checked{{end}}> Yes</label>
+<label><input type="radio" name="{{.Path}}" value="no"{{if ne .Value "yes"}} checked{{end}}> No</label>
+{{- else if eq .Type "choice"}}
+<span>{{.Label}}</span>
+{{- $q := .}}
+{{- range $i, $option := .Options}}
+<br><label><input type="radio" name="{{$q.Path}}" value="{{choice $i}}"{{if eq $q.Value (choice $i)}} checked{{end}}> {{$option}}</label>
+{{- end}}
+{{- else if .Secret}}
+<label>{{.Label}}
+<input type="password" name="{{.Path}}" value="" autocomplete="off"></label>
+{{- else}}
+<label>{{.Label}}{{if .Hint}} [{{.Hint}}]{{end}}
+<input type="text" name="{{.Path}}" value="{{.Value}}"></label>
+{{- end}}
+{{- if .Help}}
+<br><small>{{.Help}}</small>
+{{- end}}
+{{- if .Error}}
+<br><strong>{{.Error}}</strong>
+{{- end}}
+</p>
+{{- end}}
+<p><button type="submit">Continue</button></p>
+</form>
+{{- else if .Summary}}
+<pre>{{.Summary}}</pre>
+{{- end}}
+{{- if .Resume}}
+<form method="post" action="/apply/{{.ID}}">
+<input type="hidden" name="form" value="">
+<input type="hidden" name="version" value="{{.Version}}">
+<p><button type="submit">Continue</button></p>
+</form>
+{{- end}}
+<p>Application {{.ID}}</p>
+</body>
+</html>
+`))
+
+// Page to start an application
+const webStart = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Loan application</title></head>
+<body>
+<h1>Welcome to your loan portal</h1>
+<p>We will collect some basic information about you now to get you started in your application.</p>
+<form method="post" action="/apply"><button type="submit">Start</button></form>
+</body>
+</html>
+`
+
+// webApply
+//
+// Applicants' front end: pages generated from the forms of the tasks
+type webApply struct {
+	workflow string
+	mu       sync.Mutex          // guards pages
+	pages    map[string]*webForm // forms left pending, by application
+}
+
+// start
+//
+// GET /apply shows the start page, POST /apply starts an application and
+// redirects to its page
+func (web *webApply) start(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(webStart))
+	case http.MethodPost:
+		ctx, err := newContext(web.workflow)
+		if err == nil {
+			err = ctx.Save()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Execute it up to its first form, shown by the page redirected to
+		unlock := lockApplication(ctx.ID)
+		web.execute(ctx, &webForm{})
+		unlock()
+		http.Redirect(w, req, "/apply/"+ctx.ID, http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// execute
+//
+// Execute the application with the answers of ui, keeping the form left
+// pending so that the page can be shown again without executing it.
+// resume tells that it must be loaded again, saved meanwhile by another
+// writer. Must be called with the lock of the application held.
+func (web *webApply) execute(ctx *Context, ui *webForm) (title, notice string, resume bool) {
+	ctx.ui = ui
+	if err := ctx.scheduleReminders(); err != nil {
+		log.Printf("application %s: %v", ctx.ID, err)
+	}
+	title = "Your loan application"
+	err := ctx.Execute()
+	if errors.Is(err, ErrConflict) {
+		notice, resume, ui.pending = "Your application was changed meanwhile, please continue.", true, nil
+	} else if errors.Is(err, workflow.ErrWaiting) && ui.pending == nil && ctx.AwaitingReview() {
+		title = "Your application was submitted"
+	} else if err != nil && !errors.Is(err, workflow.ErrWaiting) {
+		log.Printf("application %s: %v", ctx.ID, err)
+		notice = "Your application could not be processed, please try again later."
+	} else if err == nil {
+		title = "Your application is complete"
+	}
+
+	web.mu.Lock()
+	defer web.mu.Unlock()
+	if web.pages == nil {
+		web.pages = make(map[string]*webForm)
+	}
+	delete(web.pages, ctx.ID)
+	if ui.pending != nil {
+		ctx.Lock()
+		ui.version = ctx.Version
+		ctx.Unlock()
+		web.pages[ctx.ID] = ui
+	}
+	return title, notice, resume
+}
+
+// application
+//
+// GET /apply/{id} shows the pending form of the application, POST
+// /apply/{id} answers it. Only answers posted for the current version of
+// the application execute it: a page shown again, or posted from a page
+// out of date, shows the form left pending as of that version, or offers
+// to resume the application when it is not known, e.g. after a restart.
+func (web *webApply) application(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/apply/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	unlock := lockApplication(id)
+	defer unlock()
+	ctx, err := loadContext(id)
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ui := &webForm{}
+	notice, answered := "", false
+	if req.Method == http.MethodPost {
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.PostForm.Get("version") == strconv.Itoa(ctx.Version) {
+			// Questions left unanswered on the page, e.g. radio buttons
+			// not selected, are answered empty
+			ui.name, ui.answers = req.PostForm.Get("form"), map[string]string{}
+			for _, path := range req.PostForm["asked"] {
+				ui.answers[path] = req.PostForm.Get(path)
+			}
+			answered = true
+		} else {
+			notice = "This page was out of date, please review your answers."
+		}
+	}
+
+	title, resume := "Your loan application", false
+	switch status := ctx.Status(); {
+	case status == "review":
+		title = "Your application is awaiting review"
+	case status != "open":
+		title = "Your application was " + status
+	case ctx.Finished():
+		title = "Your application is complete"
+	case answered:
+		if title, notice, resume = web.execute(ctx, ui); resume {
+			// Show the version saved meanwhile
+			if saved, err := loadContext(id); err == nil {
+				ctx = saved
+			}
+		}
+	default:
+		web.mu.Lock()
+		page, ok := web.pages[ctx.ID]
+		web.mu.Unlock()
+		if ok && page.version == ctx.Version {
+			ui = page
+		} else {
+			resume = true
+		}
+	}
+
+	p := ui.page(ctx, title)
+	p.Error, p.Resume = notice, resume
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := webTemplate.Execute(w, p); err != nil {
+		log.Printf("application %s: %v", ctx.ID, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tuanqle/quizes/loan-processor/workflow"
+)
+
+// webhookConfig
+//
+// Webhook of the configuration file
+type webhookConfig struct {
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	Events      []string `json:"events,omitempty"`
+	MaxAttempts int      `json:"max-attempts,omitempty"`
+	Backoff     string   `json:"backoff,omitempty"`
+}
+
+// webhookEvents are the event types a webhook may subscribe to
+var webhookEvents = []string{
+	workflow.WorkflowStarted, workflow.WorkflowResumed, workflow.WorkflowCompleted, workflow.WorkflowFailed,
+	workflow.WorkflowSuspended, workflow.TaskStarted, workflow.TaskCompleted, workflow.TaskFailed,
+	workflow.TaskWaiting, workflow.WorkflowExpired, workflow.TimerFired,
+}
+
+// loadWebhooks
+//
+// Read the webhooks configured in the JSON file at path
+func loadWebhooks(path string) ([]*workflow.Webhook, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configs := []*webhookConfig{}
+	if err := json.Unmarshal(buf, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	hooks := []*workflow.Webhook{}
+	for i, c := range configs {
+		if c.URL == "" || c.Secret == "" {
+			return nil, fmt.Errorf("%s: webhook #%d needs a url and a secret", path, i+1)
+		}
+		for _, e := range c.Events {
+			if !contains(webhookEvents, e) {
+				return nil, fmt.Errorf("%s: webhook #%d has invalid event '%s'", path, i+1, e)
+			}
+		}
+
+		h := workflow.NewWebhook(c.URL, c.Secret, c.Events...)
+		if c.MaxAttempts > 0 {
+			h.MaxAttempts = c.MaxAttempts
+		}
+		if c.Backoff != "" {
+			if h.Backoff, err = time.ParseDuration(c.Backoff); err != nil {
+				return nil, fmt.Errorf("%s: webhook #%d: %v", path, i+1, err)
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// webhookFlags
+//
+// Define the -webhooks flag of a command executing applications. The
+// function returned registers the webhooks configured, and returns the
+// function waiting for their last deliveries before exiting.
+func webhookFlags(flags *flag.FlagSet) func() (func(), error) {
+	path := flags.String("webhooks", "", "webhooks configuration file")
+
+	return func() (func(), error) {
+		if *path == "" {
+			return func() {}, nil
+		}
+		hooks, err := loadWebhooks(*path)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hooks {
+			workflow.Listen(h.Notify)
+		}
+		return func() {
+			for _, h := range hooks {
+				h.Close()
+			}
+		}, nil
+	}
+}
+
+// contains
+//
+// Whether list holds s
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// receive
+//
+// `receive` command: local webhook receiver printing the deliveries
+// whose signature is valid. -fail answers the first deliveries with an
+// error to exercise retries.
+func receive(args []string) error {
+	flags := flag.NewFlagSet("receive", flag.ExitOnError)
+	addr := flags.String("addr", ":8082", "address to listen on")
+	secret := flags.String("secret", "", "secret the deliveries are signed with")
+	fail := flags.Int("fail", 0, "number of deliveries answered with 503 Service Unavailable")
+	verbose := flags.Bool("v", false, "print the body of the deliveries")
+	flags.Parse(args)
+
+	var mu sync.Mutex
+	failures := *fail
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, event := req.Header.Get(workflow.DeliveryHeader), req.Header.Get(workflow.EventHeader)
+		if !workflow.Verify(*secret, body, req.Header.Get(workflow.SignatureHeader)) {
+			log.Printf("delivery %s (%s): invalid signature", id, event)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		failing := failures > 0
+		failures--
+		mu.Unlock()
+		if failing {
+			log.Printf("delivery %s (%s): failing on purpose", id, event)
+			http.Error(w, "failing on purpose", http.StatusServiceUnavailable)
+			return
+		}
+
+		p := &workflow.Payload{}
+		if err := json.Unmarshal(body, p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("delivery %s: %s %s %s %s", p.ID, p.Type, p.Workflow, p.Task, p.Error)
+		if *verbose {
+			fmt.Printf("%s\n", body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	log.Printf("webhook receiver listening on %s", *addr)
+	return http.ListenAndServe(*addr, http.HandlerFunc(handler))
+}
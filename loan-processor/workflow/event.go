@@ -0,0 +1,70 @@
+package workflow
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	WorkflowStarted   = "workflow.started"
+	WorkflowResumed   = "workflow.resumed"
+	WorkflowCompleted = "workflow.completed"
+	WorkflowFailed    = "workflow.failed"
+	WorkflowSuspended = "workflow.suspended"
+	WorkflowExpired   = "workflow.expired"
+	TaskStarted       = "task.started"
+	TaskCompleted     = "task.completed"
+	TaskFailed        = "task.failed"
+	TaskWaiting       = "task.waiting"
+	TimerFired        = "timer.fired"
+)
+
+// Event
+//
+// Notification of a change in the execution of a run. Rerun marks a task
+// started or completed again in the same run, after waiting, failing or
+// going back
+type Event struct {
+	Type     string
+	Workflow string
+	Task     string
+	Time     time.Time
+	Elapsed  time.Duration
+	Err      error
+	Rerun    bool
+}
+
+// Listener
+//
+// Callback receiving every event of every run
+type Listener func(r *Run, ev *Event)
+
+var (
+	listenMu  sync.RWMutex
+	listeners []Listener
+)
+
+// Listen
+//
+// Register a listener for workflow events
+func Listen(l Listener) {
+	listenMu.Lock()
+	defer listenMu.Unlock()
+	listeners = append(listeners, l)
+}
+
+// emit
+//
+// Deliver an event to the listeners in registration order
+func (r *Run) emit(ev *Event) {
+	ev.Workflow = r.WorkFlow
+	ev.Time = time.Now()
+
+	listenMu.RLock()
+	ls := listeners
+	listenMu.RUnlock()
+	for _, l := range ls {
+		l(r, ev)
+	}
+}
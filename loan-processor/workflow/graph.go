@@ -0,0 +1,188 @@
+package workflow
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Transition
+//
+// Conditional branch declared by a task: when the condition described
+// by When holds, the task enables To
+type Transition struct {
+	To   string
+	When string
+}
+
+// Branches
+//
+// Transitions of a task, including the branch taken on a signal timeout
+func (t *Task) Branches() []*Transition {
+	if t.OnTimeout == "" {
+		return t.Transitions
+	}
+	within := t.Timeout.String()
+	if t.Timeout%(24*time.Hour) == 0 {
+		within = fmt.Sprintf("%d days", t.Timeout/(24*time.Hour))
+	}
+	timeout := &Transition{To: t.OnTimeout, When: fmt.Sprintf("no %s signal within %s", t.Signal, within)}
+	return append([]*Transition{timeout}, t.Transitions...)
+}
+
+// node
+//
+// Task of a workflow as rendered in a diagram
+type node struct {
+	name    string
+	kind    string
+	initial State
+	current State
+}
+
+func nodes(workName string, r *Run) ([]*Task, []*node, error) {
+	steps, ok := Lookup(workName)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid workflow '%s'", workName)
+	}
+
+	ns := make([]*node, len(steps))
+	for i, task := range steps {
+		n := &node{name: task.Name, kind: "undefined", initial: task.State}
+		if t, ok := LookupTask(task.Name); ok {
+			n.kind = t.Kind
+		}
+		if task.Workflow != "" {
+			n.kind = "workflow " + task.Workflow
+		}
+		if task.Repeat {
+			n.kind = "repeat " + task.Workflow
+		}
+		if task.Signal != "" {
+			n.kind = "signal " + task.Signal
+		}
+		if r != nil {
+			r.Lock()
+			n.current = r.States[task.Name]
+			r.Unlock()
+		}
+		ns[i] = n
+	}
+	return steps, ns, nil
+}
+
+// visited
+//
+// Tasks a run went through in the order of execution
+func visited(ns []*node) []string {
+	path := []string{}
+	for _, n := range ns {
+		switch n.current {
+		case Running, Waiting, Completed, Failed:
+			path = append(path, n.name)
+		}
+	}
+	return path
+}
+
+// overlay colors by task state
+var stateColor = map[State]string{
+	Running:   "#fff3c4",
+	Waiting:   "#bbdefb",
+	Completed: "#c8e6c9",
+	Failed:    "#ffcdd2",
+	Skipped:   "#eeeeee",
+}
+
+// WriteDOT
+//
+// Render workflow workName as a Graphviz DOT digraph. Disabled tasks are
+// dashed, transitions are labelled with their condition. When r is not nil
+// the tasks it went through are filled according to their state.
+func WriteDOT(w io.Writer, workName string, r *Run) error {
+	steps, ns, err := nodes(workName, r)
+	if err != nil {
+		return err
+	}
+
+	cw := &countWriter{w: w}
+	fmt.Fprintf(cw, "digraph %q {\n", workName)
+	fmt.Fprintln(cw, "  rankdir=LR;")
+	fmt.Fprintln(cw, "  node [shape=box, style=rounded];")
+	for _, n := range ns {
+		style := []string{"rounded"}
+		if n.initial != Enabled {
+			style = append(style, "dashed")
+		}
+		attrs := fmt.Sprintf("label=\"%s\\n(%s)\"", n.name, n.kind)
+		if color, ok := stateColor[n.current]; ok {
+			style = append(style, "filled")
+			attrs += fmt.Sprintf(", fillcolor=%q", color)
+		}
+		fmt.Fprintf(cw, "  %q [%s, style=%q];\n", n.name, attrs, strings.Join(style, ","))
+	}
+	for i := 1; i < len(ns); i++ {
+		fmt.Fprintf(cw, "  %q -> %q [color=gray];\n", ns[i-1].name, ns[i].name)
+	}
+	for _, task := range steps {
+		for _, tr := range task.Branches() {
+			fmt.Fprintf(cw, "  %q -> %q [label=%q, style=dashed];\n", task.Name, tr.To, tr.When)
+		}
+	}
+	path := visited(ns)
+	for i := 1; i < len(path); i++ {
+		fmt.Fprintf(cw, "  %q -> %q [color=blue, penwidth=2];\n", path[i-1], path[i])
+	}
+	fmt.Fprintln(cw, "}")
+	return cw.err
+}
+
+var mermaidID = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// WriteMermaid
+//
+// Render workflow workName as a Mermaid flowchart, following the same
+// conventions as WriteDOT
+func WriteMermaid(w io.Writer, workName string, r *Run) error {
+	steps, ns, err := nodes(workName, r)
+	if err != nil {
+		return err
+	}
+	id := func(name string) string {
+		return mermaidID.ReplaceAllString(name, "_")
+	}
+
+	cw := &countWriter{w: w}
+	fmt.Fprintln(cw, "flowchart LR")
+	for _, n := range ns {
+		fmt.Fprintf(cw, "  %s[\"%s (%s)\"]\n", id(n.name), n.name, n.kind)
+	}
+	for i := 1; i < len(ns); i++ {
+		fmt.Fprintf(cw, "  %s --> %s\n", id(ns[i-1].name), id(ns[i].name))
+	}
+	for _, task := range steps {
+		for _, tr := range task.Branches() {
+			fmt.Fprintf(cw, "  %s -.->|%s| %s\n", id(task.Name),
+				strings.Replace(tr.When, "|", "/", -1), id(tr.To))
+		}
+	}
+	path := visited(ns)
+	for i := 1; i < len(path); i++ {
+		fmt.Fprintf(cw, "  %s ==> %s\n", id(path[i-1]), id(path[i]))
+	}
+	fmt.Fprintln(cw, "  classDef disabled stroke-dasharray: 5 5")
+	for _, state := range []State{Running, Waiting, Completed, Failed, Skipped} {
+		fmt.Fprintf(cw, "  classDef %s fill:%s\n", state, stateColor[state])
+	}
+	for _, n := range ns {
+		if n.initial != Enabled {
+			fmt.Fprintf(cw, "  class %s disabled\n", id(n.name))
+		}
+		if _, ok := stateColor[n.current]; ok {
+			fmt.Fprintf(cw, "  class %s %s\n", id(n.name), n.current)
+		}
+	}
+	return cw.err
+}
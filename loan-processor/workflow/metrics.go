@@ -0,0 +1,259 @@
+package workflow
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// taskMetric
+//
+// Counters collected for a task of a workflow
+type taskMetric struct {
+	reached   int64
+	reruns    int64
+	completed int64
+	failed    int64
+	timed     int64
+	seconds   float64
+}
+
+// flowMetric
+//
+// Counters collected for a workflow
+type flowMetric struct {
+	started   int64
+	resumed   int64
+	completed int64
+	failed    int64
+}
+
+// Metrics
+//
+// In-process registry of workflow and task counters, rendered in
+// Prometheus text format
+type Metrics struct {
+	mu    sync.Mutex
+	flows map[string]*flowMetric
+	tasks map[string]map[string]*taskMetric
+}
+
+// Stats
+//
+// Metrics of every run executed by this process
+var Stats = NewMetrics()
+
+// NewMetrics
+//
+// Create an empty registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		flows: make(map[string]*flowMetric),
+		tasks: make(map[string]map[string]*taskMetric),
+	}
+}
+
+// Observe
+//
+// Listener updating counters from workflow events
+func (m *Metrics) Observe(r *Run, ev *Event) {
+	switch ev.Type {
+	case WorkflowStarted:
+		m.workflowStarted(ev.Workflow)
+	case WorkflowResumed:
+		m.workflowResumed(ev.Workflow)
+	case WorkflowCompleted, WorkflowFailed:
+		m.workflowFinished(ev.Workflow, ev.Err)
+	case TaskStarted:
+		m.taskStarted(ev.Workflow, ev.Task, ev.Rerun)
+	case TaskCompleted, TaskFailed:
+		m.taskFinished(ev.Workflow, ev.Task, ev.Elapsed, ev.Rerun, ev.Err)
+	}
+}
+
+func (m *Metrics) flow(workName string) *flowMetric {
+	f, ok := m.flows[workName]
+	if !ok {
+		f = &flowMetric{}
+		m.flows[workName] = f
+	}
+	return f
+}
+
+func (m *Metrics) task(workName, name string) *taskMetric {
+	flow, ok := m.tasks[workName]
+	if !ok {
+		flow = make(map[string]*taskMetric)
+		m.tasks[workName] = flow
+	}
+	t, ok := flow[name]
+	if !ok {
+		t = &taskMetric{}
+		flow[name] = t
+	}
+	return t
+}
+
+// workflowStarted
+//
+// Count a workflow execution
+func (m *Metrics) workflowStarted(workName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flow(workName).started++
+}
+
+// workflowResumed
+//
+// Count the resumption of a workflow executed before
+func (m *Metrics) workflowResumed(workName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flow(workName).resumed++
+}
+
+// workflowFinished
+//
+// Count a workflow as completed or failed according to err
+func (m *Metrics) workflowFinished(workName string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.flow(workName).failed++
+		return
+	}
+	m.flow(workName).completed++
+}
+
+// taskStarted
+//
+// Count a task as reached by the applicant the first time in a run, and
+// as a rerun when resumed or run again
+func (m *Metrics) taskStarted(workName, name string, rerun bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.task(workName, name)
+	if rerun {
+		t.reruns++
+		return
+	}
+	t.reached++
+}
+
+// taskFinished
+//
+// Count a task as failed, or completed the first time in a run, and
+// record the duration of every execution
+func (m *Metrics) taskFinished(workName, name string, elapsed time.Duration, rerun bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.task(workName, name)
+	t.timed++
+	t.seconds += elapsed.Seconds()
+	switch {
+	case err != nil:
+		t.failed++
+	case !rerun:
+		t.completed++
+	}
+}
+
+// WriteTo
+//
+// Render metrics in Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	flows := make([]string, 0, len(m.flows))
+	for name := range m.flows {
+		flows = append(flows, name)
+	}
+	sort.Strings(flows)
+
+	type sample struct {
+		name, help, kind string
+		value            func(f *flowMetric) float64
+	}
+	for _, s := range []sample{
+		{"workflow_started_total", "Workflow executions started.", "counter",
+			func(f *flowMetric) float64 { return float64(f.started) }},
+		{"workflow_resumed_total", "Workflow executions resumed.", "counter",
+			func(f *flowMetric) float64 { return float64(f.resumed) }},
+		{"workflow_completed_total", "Workflow executions completed.", "counter",
+			func(f *flowMetric) float64 { return float64(f.completed) }},
+		{"workflow_failed_total", "Workflow executions failed or abandoned.", "counter",
+			func(f *flowMetric) float64 { return float64(f.failed) }},
+	} {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+		for _, name := range flows {
+			fmt.Fprintf(cw, "%s{workflow=%q} %g\n", s.name, name, s.value(m.flows[name]))
+		}
+	}
+
+	type taskSample struct {
+		name, help, kind string
+		value            func(t *taskMetric) float64
+	}
+	for _, s := range []taskSample{
+		{"workflow_task_reached_total", "Runs reaching the task.", "counter",
+			func(t *taskMetric) float64 { return float64(t.reached) }},
+		{"workflow_task_reruns_total", "Task executions resumed or run again.", "counter",
+			func(t *taskMetric) float64 { return float64(t.reruns) }},
+		{"workflow_task_completed_total", "Runs completing the task.", "counter",
+			func(t *taskMetric) float64 { return float64(t.completed) }},
+		{"workflow_task_failed_total", "Task executions failed or abandoned.", "counter",
+			func(t *taskMetric) float64 { return float64(t.failed) }},
+		{"workflow_task_incomplete", "Tasks reached but not completed.", "gauge",
+			func(t *taskMetric) float64 { return float64(t.reached - t.completed) }},
+		{"workflow_task_duration_seconds_sum", "Total time spent in tasks.", "counter",
+			func(t *taskMetric) float64 { return t.seconds }},
+		{"workflow_task_duration_seconds_count", "Number of timed task executions.", "counter",
+			func(t *taskMetric) float64 { return float64(t.timed) }},
+	} {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+		for _, flow := range flows {
+			names := make([]string, 0, len(m.tasks[flow]))
+			for name := range m.tasks[flow] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(cw, "%s{workflow=%q,task=%q} %g\n",
+					s.name, flow, name, s.value(m.tasks[flow][name]))
+			}
+		}
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP
+//
+// Expose metrics on the `/metrics` endpoint
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// countWriter
+//
+// Writer keeping track of bytes written and the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
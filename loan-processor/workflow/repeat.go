@@ -0,0 +1,98 @@
+package workflow
+
+import (
+	"fmt"
+)
+
+// Lister
+//
+// Implemented by data holding the lists that repeated steps collect
+type Lister interface {
+	// Item returns element index of list path, appending a new element
+	// when index is the length of the list
+	Item(path string, index int) (interface{}, error)
+
+	// Truncate keeps the first n elements of list path
+	Truncate(path string, n int) error
+}
+
+// Break
+//
+// Make the current loop iteration the last one. Called by a task of a
+// repeated sub-workflow, e.g. once the client has nothing more to add.
+func (r *Run) Break() {
+	r.Lock()
+	defer r.Unlock()
+	r.Last = true
+}
+
+// Iteration
+//
+// Run of iteration index of repeated step name, nil if it has not run
+func (r *Run) Iteration(name string, index int) *Run {
+	r.Lock()
+	defer r.Unlock()
+	loop := r.Loops[name]
+	if index < 0 || index >= len(loop) {
+		return nil
+	}
+	loop[index].parent, loop[index].step = r, name
+	return loop[index]
+}
+
+// repeat
+//
+// Handler of a repeated step. Each iteration runs the sub-workflow
+// against a new element of the list, keeping its own task states so
+// that finished iterations are not run again when resuming.
+func repeat(task *Task) Handler {
+	return func(r *Run) error {
+		lister, ok := r.Data.(Lister)
+		if !ok {
+			return fmt.Errorf("task '%s': data has no list '%s'", task.Name, task.Scope)
+		}
+
+		n := 0
+		for task.MaxRepeat <= 0 || n < task.MaxRepeat {
+			data, err := lister.Item(task.Scope, n)
+			if err != nil {
+				return err
+			}
+
+			sub := r.Iteration(task.Name, n)
+			if sub == nil {
+				if sub, err = NewRun(task.Workflow, data); err != nil {
+					return err
+				}
+				r.Lock()
+				if r.Loops == nil {
+					r.Loops = make(map[string][]*Run)
+				}
+				r.Loops[task.Name] = append(r.Loops[task.Name], sub)
+				r.Unlock()
+			}
+			sub.Scope, sub.Data = scopePath(r.Scope, fmt.Sprintf("%s/%d", task.Scope, n)), data
+			sub.parent, sub.step = r, task.Name
+
+			if !sub.Finished() {
+				if err := sub.Execute(); err != nil {
+					return err
+				}
+			}
+			n++
+
+			r.Lock()
+			last := sub.Last
+			r.Unlock()
+			if last {
+				break
+			}
+		}
+
+		// Drop the iterations left over by going back to an earlier one
+		r.Lock()
+		r.Loops[task.Name] = r.Loops[task.Name][:n]
+		r.Unlock()
+		return lister.Truncate(task.Scope, n)
+	}
+}
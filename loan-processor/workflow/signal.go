@@ -0,0 +1,193 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Signal
+//
+// Event delivered to a run from the outside, e.g. by a third party,
+// along with its payload in JSON format. Once merged by the step awaiting
+// it, the signal is consumed: the step run again, e.g. after Back, awaits
+// a new one.
+type Signal struct {
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Received time.Time       `json:"received"`
+	Consumed *time.Time      `json:"consumed,omitempty"`
+}
+
+// Receiver
+//
+// Implemented by data merging the payload of the signals it awaits.
+// Otherwise the payload is decoded into the data as JSON.
+type Receiver interface {
+	Receive(signal string, payload []byte) error
+}
+
+// Signal
+//
+// Deliver signal name with its payload to the run. The step awaiting it
+// completes on the next Execute.
+func (r *Run) Signal(name string, payload []byte) error {
+	root := r.Root()
+	if !awaits(root.WorkFlow, name, map[string]bool{}) {
+		return fmt.Errorf("workflow '%s' awaits no signal '%s'", root.WorkFlow, name)
+	}
+	if len(payload) > 0 && !json.Valid(payload) {
+		return fmt.Errorf("signal '%s': payload is not valid JSON", name)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if root.Signals == nil {
+		root.Signals = make(map[string]*Signal)
+	}
+	root.Signals[name] = &Signal{Payload: payload, Received: time.Now()}
+	return nil
+}
+
+// awaits
+//
+// Whether workflow workName, or one of its sub-workflows, awaits signal
+func awaits(workName, signal string, seen map[string]bool) bool {
+	if seen[workName] {
+		return false
+	}
+	seen[workName] = true
+	steps, _ := Lookup(workName)
+	for _, task := range steps {
+		if task.Signal == signal {
+			return true
+		}
+		if task.Workflow != "" && awaits(task.Workflow, signal, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitSignal
+//
+// Handler of a step awaiting a signal. It waits until the signal is
+// delivered, then merges its payload into the data. After Timeout, it
+// enables OnTimeout and goes on waiting, OnTimeout being enabled again
+// after each Timeout until the signal is delivered. Without OnTimeout,
+// the step fails.
+func awaitSignal(task *Task) Handler {
+	return func(r *Run) error {
+		now := time.Now()
+		r.Lock()
+		sig := r.Root().Signals[task.Signal]
+		if sig != nil && sig.Consumed != nil {
+			sig = nil
+		}
+		since, ok := r.Waits[task.Name]
+		if !ok {
+			if r.Waits == nil {
+				r.Waits = make(map[string]time.Time)
+			}
+			since, r.Waits[task.Name] = now, now
+		}
+		r.Unlock()
+
+		switch {
+		case sig != nil:
+			if err := receive(r, task.Signal, sig.Payload); err != nil {
+				return err
+			}
+			r.Lock()
+			defer r.Unlock()
+			sig.Consumed = &now
+			return nil
+		case task.Timeout > 0 && !now.Before(since.Add(task.Timeout)):
+			if task.OnTimeout == "" {
+				return fmt.Errorf("signal '%s' timed out", task.Signal)
+			}
+			r.Lock()
+			defer r.Unlock()
+			r.Waits[task.Name] = now
+			if r.States[task.OnTimeout] != Running {
+				r.States[task.OnTimeout] = Enabled
+			}
+		}
+		return ErrWaiting
+	}
+}
+
+// receive
+//
+// Merge the payload of signal name into the data of the run
+func receive(r *Run, name string, payload []byte) error {
+	if receiver, ok := r.Data.(Receiver); ok {
+		return receiver.Receive(name, payload)
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	return json.Unmarshal(payload, r.Data)
+}
+
+// Deadline
+//
+// Earliest time a step of the run, or of its sub-workflows, stops
+// waiting for a signal. The run is to be executed again then.
+func (r *Run) Deadline() (time.Time, bool) {
+	steps, _ := Lookup(r.WorkFlow)
+
+	r.Lock()
+	var deadline time.Time
+	found := false
+	children := []*Run{}
+	for _, task := range steps {
+		if since, ok := r.Waits[task.Name]; ok && task.Timeout > 0 && r.States[task.Name] == Waiting {
+			if at := since.Add(task.Timeout); !found || at.Before(deadline) {
+				deadline, found = at, true
+			}
+		}
+		if sub, ok := r.Subs[task.Name]; ok {
+			children = append(children, sub)
+		}
+		children = append(children, r.Loops[task.Name]...)
+	}
+	r.Unlock()
+
+	for _, child := range children {
+		if at, ok := child.Deadline(); ok && (!found || at.Before(deadline)) {
+			deadline, found = at, true
+		}
+	}
+	return deadline, found
+}
+
+// Awaiting
+//
+// Whether a step of the run, or of its sub-workflows, is waiting for
+// signal name, or failed to merge its payload
+func (r *Run) Awaiting(name string) bool {
+	steps, _ := Lookup(r.WorkFlow)
+
+	r.Lock()
+	children := []*Run{}
+	for _, task := range steps {
+		if state := r.States[task.Name]; task.Signal == name && (state == Waiting || state == Failed) {
+			r.Unlock()
+			return true
+		}
+		if sub, ok := r.Subs[task.Name]; ok {
+			children = append(children, sub)
+		}
+		children = append(children, r.Loops[task.Name]...)
+	}
+	r.Unlock()
+
+	for _, child := range children {
+		if child.Awaiting(name) {
+			return true
+		}
+	}
+	return false
+}
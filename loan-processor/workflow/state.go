@@ -0,0 +1,172 @@
+package workflow
+
+import (
+	"fmt"
+)
+
+// State
+//
+// Lifecycle state of a task within a run
+type State string
+
+// Task states
+const (
+	Pending   State = "pending"   // disabled until an earlier task enables it
+	Enabled   State = "enabled"   // will run when reached
+	Disabled  State = "disabled"  // will not run
+	Running   State = "running"   // handler is executing
+	Waiting   State = "waiting"   // suspended until an outside action, run again on resume
+	Completed State = "completed" // handler succeeded, never re-run
+	Failed    State = "failed"    // handler failed, run again on resume
+	Skipped   State = "skipped"   // passed over while pending or disabled
+)
+
+// Initial states a workflow definition may give its tasks
+var initialStates = map[State]bool{Pending: true, Enabled: true, Disabled: true}
+
+// UnmarshalText
+//
+// Parse a state, accepting the "enable" and "disable" values of
+// applications saved before task lifecycle was introduced
+func (s *State) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "enable":
+		*s = Enabled
+	case "disable":
+		*s = Disabled
+	default:
+		*s = State(text)
+	}
+	return nil
+}
+
+// Done
+//
+// Whether a task has nothing left to do
+func (s State) Done() bool {
+	return s == Completed || s == Skipped || s == Disabled
+}
+
+// started
+//
+// Whether a task of the run has left its initial state, i.e. the run
+// was executed before
+func (r *Run) started() bool {
+	r.Lock()
+	defer r.Unlock()
+	for _, s := range r.States {
+		if !initialStates[s] {
+			return true
+		}
+	}
+	return false
+}
+
+// Finished
+//
+// Whether every task of the run is done
+func (r *Run) Finished() bool {
+	return len(r.Remaining()) == 0
+}
+
+// Remaining
+//
+// Tasks of the run which are not done yet, in the order of execution
+func (r *Run) Remaining() []string {
+	steps, _ := Lookup(r.WorkFlow)
+
+	r.Lock()
+	defer r.Unlock()
+	names := []string{}
+	for _, task := range steps {
+		if !r.States[task.Name].Done() {
+			names = append(names, task.Name)
+		}
+	}
+	return names
+}
+
+// PercentComplete
+//
+// Share of the tasks to be executed that are completed. Skipped and
+// disabled tasks are not counted.
+func (r *Run) PercentComplete() float64 {
+	r.Lock()
+	defer r.Unlock()
+	total, completed := 0, 0
+	for _, state := range r.States {
+		switch state {
+		case Skipped, Disabled:
+			continue
+		case Completed:
+			completed++
+		}
+		total++
+	}
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(completed) / float64(total)
+}
+
+// Count
+//
+// Number of tasks of the run in each state
+func (r *Run) Count() map[State]int {
+	r.Lock()
+	defer r.Unlock()
+	count := make(map[State]int)
+	for _, state := range r.States {
+		count[state]++
+	}
+	return count
+}
+
+// Back
+//
+// Go back to task name for the client to answer it again: the task is
+// enabled and the tasks following it return to their initial state,
+// discarding the runs of their sub-workflows. Within a sub-workflow or a
+// loop iteration, the parent's step is re-entered as well so that the
+// next Execute resumes into it.
+func (r *Run) Back(name string) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.back(name, false)
+}
+
+// back
+//
+// Back with the lock held. keep retains the sub-workflow runs of task
+// name itself, which is how a parent re-enters the step of a child.
+func (r *Run) back(name string, keep bool) error {
+	steps, _ := Lookup(r.WorkFlow)
+	at := -1
+	for i, task := range steps {
+		if task.Name == name {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return fmt.Errorf("workflow '%s' has no task '%s'", r.WorkFlow, name)
+	}
+
+	for i, task := range steps[at:] {
+		if i == 0 {
+			r.States[task.Name] = Enabled
+		} else {
+			r.States[task.Name] = task.State
+		}
+		if i > 0 || !keep {
+			delete(r.Subs, task.Name)
+			delete(r.Loops, task.Name)
+		}
+		delete(r.Waits, task.Name)
+	}
+	r.Last = false
+	if r.parent != nil {
+		return r.parent.back(r.step, true)
+	}
+	return nil
+}
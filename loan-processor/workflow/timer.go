@@ -0,0 +1,160 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrExpired
+//
+// Returned by Execute for a run which expired
+var ErrExpired = errors.New("run expired")
+
+// Timer
+//
+// Durable timer of a run, saved along with it: the registered task Task
+// runs at At. An idle timer is pushed back by Idle whenever a task of the
+// run starts, so that it only fires once the run has been idle that long.
+type Timer struct {
+	Task string        `json:"task"`
+	At   time.Time     `json:"at"`
+	Idle time.Duration `json:"idle,omitempty"`
+}
+
+// Schedule
+//
+// Set timer name of the run to fire task at a given time, replacing a
+// timer of the same name
+func (r *Run) Schedule(name, task string, at time.Time) error {
+	return r.schedule(name, &Timer{Task: task, At: at})
+}
+
+// ScheduleIdle
+//
+// Set timer name of the run to fire task once the run has been idle for
+// idle, replacing a timer of the same name
+func (r *Run) ScheduleIdle(name, task string, idle time.Duration) error {
+	if idle <= 0 {
+		return fmt.Errorf("timer '%s': invalid idle time %s", name, idle)
+	}
+	return r.schedule(name, &Timer{Task: task, At: time.Now().Add(idle), Idle: idle})
+}
+
+func (r *Run) schedule(name string, timer *Timer) error {
+	if _, ok := LookupTask(timer.Task); !ok {
+		return fmt.Errorf("timer '%s': no task '%s' defined", name, timer.Task)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	root := r.Root()
+	if root.Timers == nil {
+		root.Timers = make(map[string]*Timer)
+	}
+	root.Timers[name] = timer
+	return nil
+}
+
+// Cancel
+//
+// Remove timer name of the run, if any
+func (r *Run) Cancel(name string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.Root().Timers, name)
+}
+
+// touch
+//
+// Record activity on the run at now, pushing back its idle timers
+func (r *Run) touch(now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	for _, timer := range r.Root().Timers {
+		if timer.Idle > 0 {
+			timer.At = now.Add(timer.Idle)
+		}
+	}
+}
+
+// NextTimer
+//
+// Earliest time a timer of the run fires
+func (r *Run) NextTimer() (time.Time, bool) {
+	r.Lock()
+	defer r.Unlock()
+	var next time.Time
+	found := false
+	for _, timer := range r.Root().Timers {
+		if !found || timer.At.Before(next) {
+			next, found = timer.At, true
+		}
+	}
+	return next, found
+}
+
+// Fire
+//
+// Run the tasks of the timers due at now, earliest first, and return
+// their names. A timer is removed once its task succeeded; the first
+// failure stops and is returned, leaving the timer to fire again.
+func (r *Run) Fire(now time.Time) ([]string, error) {
+	root := r.Root()
+
+	r.Lock()
+	due := []string{}
+	for name, timer := range root.Timers {
+		if !now.Before(timer.At) {
+			due = append(due, name)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return root.Timers[due[i]].At.Before(root.Timers[due[j]].At)
+	})
+	r.Unlock()
+
+	fired := []string{}
+	for _, name := range due {
+		r.Lock()
+		timer, ok := root.Timers[name]
+		r.Unlock()
+		if !ok {
+			// Cancelled by an earlier timer
+			continue
+		}
+		t, ok := LookupTask(timer.Task)
+		if !ok {
+			return fired, fmt.Errorf("timer '%s': no task '%s' defined", name, timer.Task)
+		}
+		if err := t.Handler(root); err != nil {
+			return fired, fmt.Errorf("timer '%s': %v", name, err)
+		}
+		r.Lock()
+		if root.Timers[name] == timer {
+			delete(root.Timers, name)
+		}
+		r.Unlock()
+		fired = append(fired, name)
+		root.emit(&Event{Type: TimerFired, Task: timer.Task})
+	}
+	return fired, nil
+}
+
+// Expire
+//
+// Mark the run as expired at now, cancelling its timers. Execute then
+// refuses to resume it.
+func (r *Run) Expire(now time.Time) {
+	root := r.Root()
+	r.Lock()
+	if root.Expired != nil {
+		r.Unlock()
+		return
+	}
+	root.Expired = &now
+	root.Timers = nil
+	r.Unlock()
+	root.emit(&Event{Type: WorkflowExpired})
+}
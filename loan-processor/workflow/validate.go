@@ -0,0 +1,111 @@
+package workflow
+
+import (
+	"fmt"
+)
+
+// Validate
+//
+// Check the definition of workflow workName and report every problem
+// found: unregistered or duplicate tasks, unknown or recursive
+// sub-workflows, unknown states, transitions and timeouts to tasks
+// outside the workflow and tasks which can never be enabled.
+func Validate(workName string) []error {
+	steps, ok := Lookup(workName)
+	if !ok {
+		return []error{fmt.Errorf("invalid workflow '%s'", workName)}
+	}
+
+	errs := []error{}
+	report := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("workflow '%s': "+format, append([]interface{}{workName}, args...)...))
+	}
+
+	position := make(map[string]int)
+	for i, task := range steps {
+		if _, ok := position[task.Name]; ok {
+			report("duplicate task '%s'", task.Name)
+			continue
+		}
+		position[task.Name] = i
+		if task.Repeat && task.Workflow == "" {
+			report("task '%s' repeats no workflow", task.Name)
+		}
+		if task.Signal != "" && task.Workflow != "" {
+			report("task '%s' both awaits a signal and runs a workflow", task.Name)
+		}
+		if task.OnTimeout != "" && (task.Signal == "" || task.Timeout <= 0) {
+			report("task '%s' has no signal timeout to branch on", task.Name)
+		}
+		if task.Workflow != "" {
+			if _, ok := Lookup(task.Workflow); !ok {
+				report("task '%s' runs unknown workflow '%s'", task.Name, task.Workflow)
+			} else if nested(task.Workflow, workName, map[string]bool{}) {
+				report("task '%s' runs workflow '%s' which runs '%s' again", task.Name, task.Workflow, workName)
+			}
+		} else if _, ok := LookupTask(task.Name); !ok && task.Signal == "" {
+			report("task '%s' is not registered", task.Name)
+		}
+		if !initialStates[task.State] {
+			report("task '%s' has invalid state '%s'", task.Name, task.State)
+		}
+	}
+
+	// A pending or disabled task only runs when an earlier task enables it
+	reachable := make(map[string]bool)
+	for i, task := range steps {
+		for _, tr := range task.Branches() {
+			at, ok := position[tr.To]
+			if !ok {
+				report("task '%s' branches to unknown task '%s'", task.Name, tr.To)
+				continue
+			}
+			if at <= i {
+				report("task '%s' branches to '%s' which does not follow it", task.Name, tr.To)
+				continue
+			}
+			reachable[tr.To] = true
+		}
+	}
+	for i, task := range steps {
+		if position[task.Name] != i {
+			continue
+		}
+		if task.State != Enabled && !reachable[task.Name] {
+			report("task '%s' can never be enabled", task.Name)
+		}
+	}
+	return errs
+}
+
+// nested
+//
+// Whether workflow workName runs target, directly or through its own
+// sub-workflows
+func nested(workName, target string, seen map[string]bool) bool {
+	if workName == target {
+		return true
+	}
+	if seen[workName] {
+		return false
+	}
+	seen[workName] = true
+	steps, _ := Lookup(workName)
+	for _, task := range steps {
+		if task.Workflow != "" && nested(task.Workflow, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAll
+//
+// Validate every registered workflow
+func ValidateAll() []error {
+	errs := []error{}
+	for _, name := range Workflows() {
+		errs = append(errs, Validate(name)...)
+	}
+	return errs
+}
@@ -0,0 +1,255 @@
+package workflow
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook headers
+const (
+	EventHeader     = "X-Workflow-Event"
+	DeliveryHeader  = "X-Workflow-Delivery"
+	SignatureHeader = "X-Workflow-Signature"
+)
+
+// Webhook
+//
+// Outbound HTTP notification of workflow events. Each event is posted as
+// JSON along with the run's root data, as exported when it is an
+// Exporter, signed with an HMAC-SHA256 of the
+// body in SignatureHeader. Deliveries are sent in order from a queue so
+// that the workflow is not slowed down, and retried with exponential
+// backoff on network errors and 5xx or 429 responses. Events are dropped
+// while the queue is full.
+type Webhook struct {
+	URL         string
+	Secret      string
+	Events      []string      // event types delivered, all when empty
+	MaxAttempts int           // attempts per delivery
+	Backoff     time.Duration // delay before the first retry, doubled after each
+	Client      *http.Client
+
+	once  sync.Once
+	queue chan *delivery
+	wg    sync.WaitGroup
+}
+
+// Exporter
+//
+// Data sent to webhooks as it exports itself, e.g. without the
+// sensitive fields
+type Exporter interface {
+	Export() interface{}
+}
+
+// Payload
+//
+// Body of a webhook delivery
+type Payload struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Workflow string          `json:"workflow"`
+	Task     string          `json:"task,omitempty"`
+	Time     time.Time       `json:"time"`
+	Error    string          `json:"error,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+type delivery struct {
+	payload *Payload
+	body    []byte
+}
+
+// NewWebhook
+//
+// Webhook posting the events of the given types to url
+func NewWebhook(url, secret string, events ...string) *Webhook {
+	return &Webhook{
+		URL:         url,
+		Secret:      secret,
+		Events:      events,
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Sign
+//
+// Signature of a webhook body: "sha256=" and the hex HMAC-SHA256 of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify
+//
+// Whether signature is the signature of body, for webhook receivers
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// wants
+//
+// Whether the webhook delivers events of type t
+func (h *Webhook) wants(t string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify
+//
+// Listener queuing the delivery of an event. The data is captured when
+// the event occurs. When the queue is full, e.g. the endpoint is slow
+// or down, the event is dropped and logged rather than holding up the
+// workflow.
+func (h *Webhook) Notify(r *Run, ev *Event) {
+	if !h.wants(ev.Type) {
+		return
+	}
+
+	p := &Payload{
+		ID:       deliveryID(),
+		Type:     ev.Type,
+		Workflow: ev.Workflow,
+		Task:     ev.Task,
+		Time:     ev.Time,
+	}
+	if ev.Err != nil {
+		p.Error = ev.Err.Error()
+	}
+	if data := r.Root().Data; data != nil {
+		r.Lock()
+		if e, ok := data.(Exporter); ok {
+			data = e.Export()
+		}
+		buf, err := json.Marshal(data)
+		r.Unlock()
+		if err != nil {
+			log.Printf("webhook %s: %v", h.URL, err)
+			return
+		}
+		p.Data = buf
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("webhook %s: %v", h.URL, err)
+		return
+	}
+
+	h.once.Do(func() {
+		h.queue = make(chan *delivery, 100)
+		go h.run()
+	})
+	h.wg.Add(1)
+	select {
+	case h.queue <- &delivery{payload: p, body: body}:
+	default:
+		h.wg.Done()
+		log.Printf("webhook %s: queue full, delivery %s (%s) dropped", h.URL, p.ID, p.Type)
+	}
+}
+
+// Close
+//
+// Wait until the queued deliveries are sent or given up
+func (h *Webhook) Close() {
+	h.wg.Wait()
+}
+
+// run
+//
+// Send the queued deliveries in order
+func (h *Webhook) run() {
+	for d := range h.queue {
+		if err := h.deliver(d); err != nil {
+			log.Printf("webhook %s: delivery %s (%s) given up: %v", h.URL, d.payload.ID, d.payload.Type, err)
+		}
+		h.wg.Done()
+	}
+}
+
+// deliver
+//
+// Post a delivery, retrying with exponential backoff
+func (h *Webhook) deliver(d *delivery) error {
+	attempts := h.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := h.Backoff
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		if retry, err = h.post(d); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post
+//
+// Post a delivery once, telling whether a failure is worth retrying
+func (h *Webhook) post(d *delivery) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.payload.Type)
+	req.Header.Set(DeliveryHeader, d.payload.ID)
+	req.Header.Set(SignatureHeader, Sign(h.Secret, d.body))
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("%s", resp.Status)
+	}
+	return false, fmt.Errorf("%s", resp.Status)
+}
+
+// deliveryID
+//
+// Random identifier letting receivers discard duplicate deliveries
+func deliveryID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,552 @@
+// Package workflow is a small engine executing a series of tasks in order.
+//
+// Tasks are registered once with RegisterTask and arranged into named
+// workflows with RegisterWorkflow. A Run holds the state of one execution
+// of a workflow, along with the client's data which is handed to every
+// task handler. A handler may enable or disable the tasks that follow it
+// based on the client's responses. A step of a workflow may also run
+// another workflow, against a part of the client's data, once or
+// repeatedly for each element of a list.
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Task kinds
+const (
+	RPC = "rpc" // wait until the task is completed before returning
+	BG  = "bg"  // launch the task and return to caller immediately
+)
+
+// Handler
+//
+// Task handler syntax
+type Handler func(run *Run) error
+
+// ErrWaiting
+//
+// Returned by a handler waiting for an outside action, such as a human
+// decision. The task is left Waiting and the workflow is suspended: Execute
+// returns ErrWaiting, and runs the task again when called after the action.
+var ErrWaiting = errors.New("waiting for an action")
+
+// TaskFunc
+//
+// Settings of how to execute a registered task
+type TaskFunc struct {
+	Name    string
+	Kind    string
+	Handler Handler
+	Error   error
+	wg      *sync.WaitGroup
+}
+
+// Task
+//
+// Step of a workflow along with its initial state and the follow-up
+// tasks its handler may enable. When Workflow is set, the step runs that
+// workflow as a sub-workflow instead of the task registered under Name.
+// Scope then selects the part of the data handed to the sub-workflow.
+// With Repeat, the sub-workflow runs once per element of the list Scope
+// until an iteration calls Break, or MaxRepeat iterations when set.
+// When Signal is set, the step waits for that signal instead, along with
+// the following steps awaiting signals, enabling OnTimeout each time it
+// is not delivered within Timeout.
+type Task struct {
+	Name        string
+	State       State
+	Workflow    string
+	Scope       string
+	Repeat      bool
+	MaxRepeat   int
+	Signal      string
+	Timeout     time.Duration
+	OnTimeout   string
+	Transitions []*Transition
+}
+
+// Scoper
+//
+// Implemented by data which can hand a part of itself to sub-workflows
+type Scoper interface {
+	Scope(path string) (interface{}, error)
+}
+
+var (
+	mu        sync.RWMutex
+	tasks     = map[string]TaskFunc{}
+	workflows = map[string][]*Task{}
+)
+
+// RegisterTask
+//
+// Register a task handler under name. kind is either RPC or BG.
+func RegisterTask(name, kind string, handler Handler) error {
+	if handler == nil {
+		return fmt.Errorf("task '%s' has no handler", name)
+	}
+	if kind != RPC && kind != BG {
+		return fmt.Errorf("task '%s' has invalid kind '%s'", name, kind)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := tasks[name]; ok {
+		return fmt.Errorf("task '%s' already registered", name)
+	}
+	tasks[name] = TaskFunc{Name: name, Kind: kind, Handler: handler}
+	return nil
+}
+
+// RegisterWorkflow
+//
+// Register a named series of tasks in the order of execution
+func RegisterWorkflow(name string, steps []*Task) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := workflows[name]; ok {
+		return fmt.Errorf("workflow '%s' already registered", name)
+	}
+	workflows[name] = steps
+	return nil
+}
+
+// Lookup
+//
+// Return the tasks of a registered workflow
+func Lookup(name string) ([]*Task, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	steps, ok := workflows[name]
+	return steps, ok
+}
+
+// LookupTask
+//
+// Return the settings of a registered task
+func LookupTask(name string) (TaskFunc, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := tasks[name]
+	return t, ok
+}
+
+// Workflows
+//
+// Names of the registered workflows in alphabetical order
+func Workflows() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(workflows))
+	for name := range workflows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run
+//
+// State of one execution of a workflow. Data holds the client's data and
+// is not interpreted by the engine. The runs of sub-workflows are nested
+// under Subs by step name, and those of repeated steps under Loops.
+// Signals delivered to the run are kept by the root run, and Waits holds
+// when each step started waiting for its signal. The root run also keeps
+// the run's timers, and when it expired. A root run set Serial waits for
+// background tasks like the others, so that it always runs the same way.
+type Run struct {
+	WorkFlow string               `json:"work-flow"`
+	Scope    string               `json:"scope,omitempty"`
+	States   map[string]State     `json:"states"`
+	Seen     map[string]State     `json:"seen,omitempty"`
+	Subs     map[string]*Run      `json:"subs,omitempty"`
+	Loops    map[string][]*Run    `json:"loops,omitempty"`
+	Last     bool                 `json:"last,omitempty"`
+	Signals  map[string]*Signal   `json:"signals,omitempty"`
+	Waits    map[string]time.Time `json:"waits,omitempty"`
+	Timers   map[string]*Timer    `json:"timers,omitempty"`
+	Expired  *time.Time           `json:"expired,omitempty"`
+	Data     interface{}          `json:"-"`
+	Serial   bool                 `json:"-"`
+	mu       sync.Mutex
+	parent   *Run
+	step     string
+	bg       sync.WaitGroup
+	stop     error
+}
+
+// NewRun
+//
+// Prepare a run of workflow workName with the tasks' initial states
+func NewRun(workName string, data interface{}) (*Run, error) {
+	steps, ok := Lookup(workName)
+	if !ok {
+		return nil, fmt.Errorf("invalid workflow '%s'", workName)
+	}
+
+	r := &Run{
+		WorkFlow: workName,
+		States:   make(map[string]State),
+		Data:     data,
+	}
+	for _, t := range steps {
+		r.States[t.Name] = t.State
+	}
+	return r, nil
+}
+
+// Root
+//
+// Top-most run of nested sub-workflows
+func (r *Run) Root() *Run {
+	for r.parent != nil {
+		r = r.parent
+	}
+	return r
+}
+
+// Parent
+//
+// Run of the workflow which runs this one as a sub-workflow, nil at the root
+func (r *Run) Parent() *Run {
+	return r.parent
+}
+
+// Lock
+//
+// Lock the run along with its parent and sub-workflow runs
+func (r *Run) Lock() {
+	r.Root().mu.Lock()
+}
+
+// Unlock
+//
+// Unlock the run along with its parent and sub-workflow runs
+func (r *Run) Unlock() {
+	r.Root().mu.Unlock()
+}
+
+// Enable
+//
+// Enable a follow-up task
+func (r *Run) Enable(name string) error {
+	return r.decide(name, Enabled)
+}
+
+// Disable
+//
+// Disable a follow-up task
+func (r *Run) Disable(name string) error {
+	return r.decide(name, Disabled)
+}
+
+// decide
+//
+// Enable or disable a task which has not run yet. Unlike a map write,
+// a task which is not part of the workflow is reported.
+func (r *Run) decide(name string, state State) error {
+	r.Lock()
+	defer r.Unlock()
+	current, ok := r.States[name]
+	if !ok {
+		return fmt.Errorf("workflow '%s' has no task '%s'", r.WorkFlow, name)
+	}
+	switch current {
+	case Running, Completed, Failed:
+		return fmt.Errorf("task '%s' is already %s", name, current)
+	}
+	r.States[name] = state
+	return nil
+}
+
+// setState
+//
+// Record the lifecycle state of a task
+func (r *Run) setState(name string, state State) {
+	r.Lock()
+	defer r.Unlock()
+	r.States[name] = state
+}
+
+// seen
+//
+// Record that a task reached state, Running or Completed, and tell
+// whether it already had in this run. Unlike States it is kept through
+// Back, so a task resumed or run again is not counted twice.
+func (r *Run) seen(name string, state State) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.Seen == nil {
+		r.Seen = make(map[string]State)
+	}
+	prev := r.Seen[name]
+	if prev == Completed || prev == state {
+		return true
+	}
+	r.Seen[name] = state
+	return false
+}
+
+// State
+//
+// Current state of a task
+func (r *Run) State(name string) State {
+	r.Lock()
+	defer r.Unlock()
+	return r.States[name]
+}
+
+// Run
+//
+// Execute selected Task
+func (t *TaskFunc) Run(r *Run) {
+	if t.Handler == nil {
+		log.Print("Task handler is undefined")
+		return
+	}
+
+	t.wg = &sync.WaitGroup{}
+	t.wg.Add(1)
+	r.bg.Add(1)
+	go func() {
+		defer r.bg.Done()
+		defer t.wg.Done()
+		start := time.Now()
+		r.touch(start)
+		t.Error = t.Handler(r)
+		if t.Error != nil && t.Error == r.stopped() {
+			// Stopped within a sub-workflow, left running
+			return
+		}
+		if errors.Is(t.Error, ErrWaiting) {
+			r.setState(t.Name, Waiting)
+			r.emit(&Event{Type: TaskWaiting, Task: t.Name, Elapsed: time.Since(start)})
+			return
+		}
+		if t.Error != nil {
+			r.setState(t.Name, Failed)
+			r.emit(&Event{Type: TaskFailed, Task: t.Name, Elapsed: time.Since(start), Err: t.Error})
+			return
+		}
+		r.setState(t.Name, Completed)
+		rerun := r.seen(t.Name, Completed)
+		r.emit(&Event{Type: TaskCompleted, Task: t.Name, Elapsed: time.Since(start), Rerun: rerun})
+	}()
+
+	// Do not wait for parallel task, unless the run is serial
+	// kind: "bg"
+	if t.Kind != BG || r.Root().Serial {
+		t.wg.Wait()
+	}
+}
+
+// Execute
+//
+// Perform workflow tasks for the run. Completed tasks are not run again,
+// so that an interrupted run can be resumed. Background tasks are waited
+// for before the workflow is considered completed. A task waiting for an
+// outside action suspends the workflow, returning ErrWaiting. An expired
+// run is not executed anymore. A run executed before is reported as
+// resumed rather than started.
+func (r *Run) Execute() error {
+	steps, ok := Lookup(r.WorkFlow)
+	if !ok {
+		return fmt.Errorf("invalid workflow '%s'", r.WorkFlow)
+	}
+	r.Lock()
+	expired := r.Root().Expired != nil
+	r.Unlock()
+	if expired {
+		return ErrExpired
+	}
+
+	if r.started() {
+		r.emit(&Event{Type: WorkflowResumed})
+	} else {
+		r.emit(&Event{Type: WorkflowStarted})
+	}
+	err := r.execute(steps)
+	r.bg.Wait()
+	stop := r.stopped()
+	if stop != nil {
+		err = stop
+	}
+	if err == nil {
+		err = r.bgError(steps)
+	}
+	if errors.Is(err, ErrWaiting) || stop != nil {
+		r.emit(&Event{Type: WorkflowSuspended})
+		return err
+	}
+	if err != nil {
+		r.emit(&Event{Type: WorkflowFailed, Err: err})
+		return err
+	}
+	r.emit(&Event{Type: WorkflowCompleted})
+	return nil
+}
+
+// Stop
+//
+// Stop the execution of the run before its next task, Execute returning
+// err, e.g. when a listener found that the run cannot be saved. The run
+// is left suspended, to be executed again once reloaded.
+func (r *Run) Stop(err error) {
+	r.Lock()
+	defer r.Unlock()
+	if root := r.Root(); root.stop == nil {
+		root.stop = err
+	}
+}
+
+// stopped
+//
+// Error the run was stopped with, nil unless stopped
+func (r *Run) stopped() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.Root().stop
+}
+
+// execute
+//
+// Run the steps in order, until one fails or waits. Steps awaiting
+// signals wait together: the run goes on with the following steps which
+// also await signals, or which their timeouts enable, and stops at the
+// first other step to run.
+func (r *Run) execute(steps []*Task) error {
+	var waiting error
+	follows := map[string]bool{}
+	for _, task := range steps {
+		if err := r.stopped(); err != nil {
+			return err
+		}
+		t, ok := LookupTask(task.Name)
+		switch {
+		case task.Repeat:
+			t, ok = TaskFunc{Name: task.Name, Kind: RPC, Handler: repeat(task)}, true
+		case task.Workflow != "":
+			t, ok = TaskFunc{Name: task.Name, Kind: RPC, Handler: subWorkflow(task)}, true
+		case task.Signal != "":
+			t, ok = TaskFunc{Name: task.Name, Kind: RPC, Handler: awaitSignal(task)}, true
+		}
+		if !ok {
+			return fmt.Errorf("no task '%s' defined", task.Name)
+		}
+		switch r.State(task.Name) {
+		case Completed, Skipped:
+			continue
+		case Pending, Disabled:
+			// Left to be enabled by the steps still waiting
+			if waiting == nil {
+				r.setState(task.Name, Skipped)
+			}
+			continue
+		}
+		if waiting != nil && task.Signal == "" && !follows[task.Name] {
+			return waiting
+		}
+		r.setState(t.Name, Running)
+		rerun := r.seen(t.Name, Running)
+		r.emit(&Event{Type: TaskStarted, Task: t.Name, Rerun: rerun})
+		t.Run(r)
+		if t.Kind != BG && t.Error != nil {
+			if task.Signal == "" || !errors.Is(t.Error, ErrWaiting) {
+				return t.Error
+			}
+			waiting = t.Error
+			if task.OnTimeout != "" {
+				follows[task.OnTimeout] = true
+			}
+		}
+	}
+	return waiting
+}
+
+// subWorkflow
+//
+// Handler of a step running a sub-workflow. The sub-workflow's run is
+// kept under the parent's so that it is resumed rather than restarted.
+func subWorkflow(task *Task) Handler {
+	return func(r *Run) error {
+		data := r.Data
+		if task.Scope != "" {
+			scoper, ok := r.Data.(Scoper)
+			if !ok {
+				return fmt.Errorf("task '%s': data cannot be scoped to '%s'", task.Name, task.Scope)
+			}
+			var err error
+			if data, err = scoper.Scope(task.Scope); err != nil {
+				return err
+			}
+		}
+
+		sub := r.Sub(task.Name)
+		if sub == nil {
+			var err error
+			if sub, err = NewRun(task.Workflow, data); err != nil {
+				return err
+			}
+		}
+		sub.Scope, sub.Data = scopePath(r.Scope, task.Scope), data
+
+		r.Lock()
+		if r.Subs == nil {
+			r.Subs = make(map[string]*Run)
+		}
+		r.Subs[task.Name] = sub
+		sub.parent, sub.step = r, task.Name
+		r.Unlock()
+		if sub.Finished() {
+			return nil
+		}
+		return sub.Execute()
+	}
+}
+
+// scopePath
+//
+// Path of the data of a sub-workflow from the root run's data
+func scopePath(parent, scope string) string {
+	switch {
+	case scope == "":
+		return parent
+	case parent == "":
+		return scope
+	}
+	return parent + "/" + scope
+}
+
+// Sub
+//
+// Run of the sub-workflow of step name, nil until the step has run
+func (r *Run) Sub(name string) *Run {
+	r.Lock()
+	defer r.Unlock()
+	sub, ok := r.Subs[name]
+	if !ok {
+		return nil
+	}
+	sub.parent, sub.step = r, name
+	return sub
+}
+
+// bgError
+//
+// Report the first background task that failed
+func (r *Run) bgError(steps []*Task) error {
+	r.Lock()
+	defer r.Unlock()
+	for _, task := range steps {
+		if r.States[task.Name] == Failed {
+			return fmt.Errorf("task '%s' failed", task.Name)
+		}
+	}
+	return nil
+}